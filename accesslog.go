@@ -0,0 +1,38 @@
+package support
+
+import (
+	"time"
+)
+
+// Close reasons of an AccessLogEntry, besides the message of a failed read or write.
+const (
+	CloseReasonClosed        = "closed"
+	CloseReasonShutdown      = "shutdown"
+	CloseReasonIdleTimeout   = "idle timeout"
+	CloseReasonMaxDuration   = "max duration"
+	CloseReasonQuotaExceeded = "quota exceeded"
+)
+
+// AccessLogEntry is the metadata of a hijacked session, recorded when its connection is closed.
+type AccessLogEntry struct {
+	RemoteAddr  string
+	URL         string // Empty if the request is unknown
+	RequestID   string // Request ID of the X-Request-Id header of the request, if any
+	Start       time.Time
+	Duration    time.Duration
+	BytesIn     int64 // Bytes read from the client
+	BytesOut    int64 // Bytes written to the client
+	CloseReason string
+}
+
+// AccessLogger records the metadata of hijacked sessions, see ConnRegistry.AccessLogger.
+type AccessLogger interface {
+	LogSession(entry AccessLogEntry)
+}
+
+// AccessLoggerFunc is a function used as AccessLogger.
+type AccessLoggerFunc func(entry AccessLogEntry)
+
+func (f AccessLoggerFunc) LogSession(entry AccessLogEntry) {
+	f(entry)
+}
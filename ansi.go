@@ -0,0 +1,84 @@
+package support
+
+import (
+	"bytes"
+	"io"
+)
+
+// States of an ansiStripper.
+const (
+	ansiText         = iota
+	ansiEscape       // After ESC
+	ansiCSI          // In a control sequence (ESC [)
+	ansiIntermediate // After intermediate bytes of an escape sequence
+	ansiString       // In a control string (OSC, DCS, SOS, PM or APC), terminated by ST or BEL
+	ansiStringEscape // After ESC in a control string, possibly starting ST (ESC \)
+)
+
+// StripANSI is an OutputTransform removing ANSI escape sequences (colors, cursor movements, window titles, ...) from
+// the output and error streams, e.g. for capturing the output of commands run with a TTY into logs or JSON APIs.
+// Sequences split between writes are removed as well.
+func StripANSI(w io.Writer) io.Writer {
+	return &ansiStripper{w: w}
+}
+
+// ansiStripper writes the data written to it without ANSI escape sequences.
+type ansiStripper struct {
+	w     io.Writer
+	state int
+}
+
+func (s *ansiStripper) Write(p []byte) (int, error) {
+	if s.state == ansiText && bytes.IndexByte(p, 0x1b) < 0 {
+		return s.w.Write(p)
+	}
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		switch s.state {
+		case ansiText:
+			if b == 0x1b {
+				s.state = ansiEscape
+			} else {
+				out = append(out, b)
+			}
+		case ansiEscape:
+			switch {
+			case b == '[':
+				s.state = ansiCSI
+			case b == ']' || b == 'P' || b == 'X' || b == '^' || b == '_':
+				s.state = ansiString
+			case b >= 0x20 && b <= 0x2f:
+				s.state = ansiIntermediate
+			default:
+				// Final byte of a two-byte sequence like ESC 7
+				s.state = ansiText
+			}
+		case ansiCSI, ansiIntermediate:
+			if b < 0x20 || b > 0x7e {
+				// Malformed sequence, the control character is kept
+				s.state = ansiText
+				out = append(out, b)
+			} else if (s.state == ansiCSI && b >= 0x40) || (s.state == ansiIntermediate && b >= 0x30) {
+				s.state = ansiText
+			}
+		case ansiString:
+			if b == 0x07 {
+				s.state = ansiText
+			} else if b == 0x1b {
+				s.state = ansiStringEscape
+			}
+		case ansiStringEscape:
+			if b == '\\' {
+				s.state = ansiText
+			} else {
+				s.state = ansiString
+			}
+		}
+	}
+	if len(out) > 0 {
+		if _, err := s.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
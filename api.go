@@ -0,0 +1,157 @@
+package support
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIError is returned when a (non hijacked) API call responds with an error status.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error (%d): %s", e.StatusCode, e.Message)
+}
+
+// apiClient performs regular (non hijacked) HTTP calls to an API endpoint,
+// dialing it using the transport registered for the scheme of the endpoint.
+type apiClient struct {
+	endpoint string
+	version  string // If set, paths are prefixed with this API version.
+	header   http.Header
+	client   *http.Client
+}
+
+// apiIdleConnTimeout is the time idle connections of API clients are kept open for reuse.
+const apiIdleConnTimeout = 90 * time.Second
+
+var (
+	apiClientsMutex sync.Mutex
+	apiClients      = map[string]*http.Client{} // HTTP clients by endpoint, so connections are reused instead of leaked
+)
+
+// newAPIClient creates a client for the API at the given endpoint URL.
+func newAPIClient(endpoint string, header http.Header) (*apiClient, error) {
+	ep, err := neturl.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	base := strings.TrimRight(endpoint, "/")
+	if ep.Scheme != "http" && ep.Scheme != "https" {
+		// The connection is established by the registered transport, the request only needs a valid URL
+		base = "http://" + ep.Scheme
+	}
+	return &apiClient{
+		endpoint: base,
+		header:   header,
+		client:   endpointHTTPClient(endpoint, ep),
+	}, nil
+}
+
+// splitEndpointURL splits the given URL into the URL of its API endpoint and the request path, keeping the socket
+// path of unix URLs in the endpoint (see splitUnixPath).
+func splitEndpointURL(ep *neturl.URL) (endpoint, path string) {
+	if ep.Scheme == "unix" {
+		socket, path := splitUnixPath(ep.Path)
+		return "unix://" + socket, path
+	}
+	return ep.Scheme + "://" + ep.Host, ep.Path
+}
+
+// endpointHTTPClient returns the HTTP client dialing the given endpoint, creating it on first use.
+func endpointHTTPClient(endpoint string, ep *neturl.URL) *http.Client {
+	apiClientsMutex.Lock()
+	defer apiClientsMutex.Unlock()
+	if client, ok := apiClients[endpoint]; ok {
+		return client
+	}
+	dialEndpoint := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dial(ep)
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext:     dialEndpoint,
+			DialTLSContext:  dialEndpoint,
+			IdleConnTimeout: apiIdleConnTimeout,
+		},
+	}
+	apiClients[endpoint] = client
+	return client
+}
+
+// get performs a GET call on the given path, discarding the response body.
+func (c *apiClient) get(path string) (*http.Response, error) {
+	res, err := c.open("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	return res, nil
+}
+
+// do performs a call with the given method and path, sending in (if not nil) and decoding the response into out (if not nil).
+func (c *apiClient) do(method, path string, in, out interface{}) error {
+	res, err := c.open(method, path, in)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// open performs a call with the given method and path, sending in (if not nil).
+// The caller must close the body of the returned response.
+func (c *apiClient) open(method, path string, in interface{}) (*http.Response, error) {
+	var body io.Reader
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewBuffer(buf)
+	}
+	req, err := http.NewRequest(method, c.endpoint+versionedPath(path, c.version), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, values := range c.header {
+		req.Header[k] = values
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode > 299 {
+		defer res.Body.Close()
+		data, _ := ioutil.ReadAll(res.Body)
+		var msg struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Message == "" {
+			msg.Message = strings.TrimSpace(string(data))
+		}
+		return nil, &APIError{StatusCode: res.StatusCode, Message: msg.Message}
+	}
+	return res, nil
+}
@@ -0,0 +1,69 @@
+package support
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+
+	docker "github.com/giantswarm/hijack-stream-support/docker"
+)
+
+type AttachOptions struct {
+	InputStream  io.Reader // If set, stdin of the container is attached.
+	OutputStream io.Writer // If set, stdout of the container is attached.
+	ErrorStream  io.Writer // If set, stderr of the container is attached.
+	Logs         bool      // If set, previous output of the container is returned first.
+	Stream       bool      // If set, output of the container is streamed until it exits.
+	Tty          bool      // Must be set if the container has a TTY, in which case output is not multiplexed.
+	DetachKeys   string    // If set, overrides the key sequence for detaching.
+	APIVersion   string    // If set, the docker API of this version (e.g. "1.41") is used. Use "auto" to negotiate the version with the daemon.
+	Header       http.Header
+	Log          docker.Logger
+	ErrorHandler func(res *http.Response, err error) error
+}
+
+var ErrMissingContainerID = errors.New("Container ID not set")
+
+// AttachToContainer attaches to the container with the given ID using the docker API at the given endpoint
+// (e.g. `https://docker.example.com:2376`) and streams data from/to the streams in the given options.
+func AttachToContainer(endpoint, containerID string, options AttachOptions) error {
+	if containerID == "" {
+		return ErrMissingContainerID
+	}
+
+	query := neturl.Values{}
+	setBoolParam(query, "stdin", options.InputStream != nil)
+	setBoolParam(query, "stdout", options.OutputStream != nil)
+	setBoolParam(query, "stderr", options.ErrorStream != nil)
+	setBoolParam(query, "logs", options.Logs)
+	setBoolParam(query, "stream", options.Stream)
+	if options.DetachKeys != "" {
+		query.Set("detachKeys", options.DetachKeys)
+	}
+
+	version, err := resolveAPIVersion(endpoint, options.APIVersion, options.Header)
+	if err != nil {
+		return err
+	}
+
+	return HijackHttpRequest(HijackHttpOptions{
+		Method:             "POST",
+		Url:                strings.TrimRight(endpoint, "/") + versionedPath("/containers/"+neturl.PathEscape(containerID)+"/attach", version) + "?" + query.Encode(),
+		DockerTermProtocol: !options.Tty,
+		InputStream:        options.InputStream,
+		OutputStream:       options.OutputStream,
+		ErrorStream:        options.ErrorStream,
+		Header:             options.Header,
+		Log:                options.Log,
+		ErrorHandler:       options.ErrorHandler,
+	})
+}
+
+// setBoolParam sets the given query parameter to 1 if value is true.
+func setBoolParam(query neturl.Values, name string, value bool) {
+	if value {
+		query.Set(name, "1")
+	}
+}
@@ -0,0 +1,105 @@
+package support
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrUnauthorized = errors.New("Unauthorized")
+	ErrForbidden    = errors.New("Forbidden")
+)
+
+// AuthFunc authenticates and authorizes a request before its connection is hijacked, since a clean HTTP error
+// cannot be sent afterwards. Errors wrapping ErrUnauthorized (see Unauthorized) are answered with 401 Unauthorized,
+// any other error with 403 Forbidden.
+type AuthFunc func(req *http.Request) error
+
+// Unauthorized returns an error wrapping ErrUnauthorized that is answered with the given WWW-Authenticate challenge.
+func Unauthorized(challenge string) error {
+	return &unauthorizedError{challenge: challenge}
+}
+
+// unauthorizedError is an ErrUnauthorized with a WWW-Authenticate challenge.
+type unauthorizedError struct {
+	challenge string
+}
+
+func (e *unauthorizedError) Error() string {
+	return ErrUnauthorized.Error()
+}
+
+func (e *unauthorizedError) Is(target error) bool {
+	return target == ErrUnauthorized
+}
+
+// rejectedError is returned by HijackServerWithOptions for requests rejected by the Auth function of the options.
+// The response has already been written.
+type rejectedError struct {
+	err error
+}
+
+func (e *rejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rejectedError) Unwrap() error {
+	return e.err
+}
+
+// BasicAuth returns an AuthFunc accepting requests with HTTP basic auth credentials for which valid returns true.
+// Other requests are challenged for credentials of the given realm.
+func BasicAuth(realm string, valid func(user, password string) bool) AuthFunc {
+	return func(req *http.Request) error {
+		if user, password, ok := req.BasicAuth(); ok && valid(user, password) {
+			return nil
+		}
+		return Unauthorized(`Basic realm="` + strings.ReplaceAll(realm, `"`, `\"`) + `", charset="UTF-8"`)
+	}
+}
+
+// BearerAuth returns an AuthFunc accepting requests with a bearer token for which valid returns true.
+func BearerAuth(valid func(token string) bool) AuthFunc {
+	return func(req *http.Request) error {
+		auth := req.Header.Get("Authorization")
+		if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") && valid(auth[7:]) {
+			return nil
+		}
+		return Unauthorized("Bearer")
+	}
+}
+
+// StaticBearerAuth returns an AuthFunc accepting requests with the given bearer token, compared in constant time.
+func StaticBearerAuth(token string) AuthFunc {
+	return BearerAuth(func(t string) bool {
+		return subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1
+	})
+}
+
+// RequireAuth returns a handler that calls next only for requests accepted by auth and answers others with
+// 401 or 403 (see AuthFunc). It protects handlers like HijackHandler, CommandHandler or the handlers of a
+// SessionManager, including their websocket and SSE variants.
+func RequireAuth(auth AuthFunc, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := auth(req); err != nil {
+			writeAuthError(w, err)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// writeAuthError answers a request rejected with the given error of an AuthFunc.
+func writeAuthError(w http.ResponseWriter, err error) {
+	var unauthorized *unauthorizedError
+	if errors.As(err, &unauthorized) && unauthorized.challenge != "" {
+		w.Header().Set("WWW-Authenticate", unauthorized.challenge)
+	}
+	if errors.Is(err, ErrUnauthorized) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusForbidden)
+}
@@ -0,0 +1,106 @@
+package support
+
+import (
+	"errors"
+	"io"
+
+	"golang.org/x/net/websocket"
+)
+
+// Channels of the v4.channel.k8s.io protocol, sent as first byte of every websocket message.
+const (
+	ChannelStdin  byte = 0
+	ChannelStdout byte = 1
+	ChannelStderr byte = 2
+	ChannelError  byte = 3
+	ChannelResize byte = 4
+)
+
+// ChannelProtocolName is the websocket subprotocol negotiated in ChannelProtocol mode.
+const ChannelProtocolName = "v4.channel.k8s.io"
+
+var ErrChannelProtocolWithoutWebSocket = errors.New("ChannelProtocol requires a websocket URL")
+
+// channelWriter writes everything written to it in-band on a channel.
+type channelWriter struct {
+	s       *Session
+	channel byte
+}
+
+func (w *channelWriter) Write(p []byte) (int, error) {
+	if err := w.s.sendChannel(w.channel, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// streamChannels streams data from/to the given websocket of the given session, (de)multiplexing the streams
+// using the channel byte prefix of the v4.channel.k8s.io protocol.
+func streamChannels(s *Session, ws *websocket.Conn) error {
+	options := s.options
+	recorder, err := s.startRecording()
+	if err != nil {
+		return err
+	}
+	if recorder != nil {
+		defer recorder.Close()
+	}
+	if in, closeInput := inputReader(options, recorder); in != nil {
+		in = s.countReader(in, &s.bytesOut, DirectionOut)
+		go func() {
+			defer closeInput()
+			buf := make([]byte, 32*1024+1)
+			buf[0] = ChannelStdin
+			for {
+				n, err := in.Read(buf[1:])
+				if n > 0 {
+					if err := websocket.Message.Send(ws, buf[:n+1]); err != nil {
+						levels(options.Log).Warnf("Sending stdin failed %#v", err)
+						return
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	stdout, stderr, closeOutput := outputWriters(options, recorder)
+	err = receiveChannels(s, ws, stdout, stderr)
+	if cerr := closeOutput(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// receiveChannels writes the output and error streams received from the given websocket to the given writers until
+// the websocket is closed or the error channel is received.
+func receiveChannels(s *Session, ws *websocket.Conn, stdout, stderr io.Writer) error {
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(ws, &msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		s.countBytes(len(msg), &s.bytesIn, DirectionIn)
+		if len(msg) == 0 {
+			continue
+		}
+		switch msg[0] {
+		case ChannelStdout:
+			if _, err := stdout.Write(msg[1:]); err != nil {
+				return err
+			}
+		case ChannelStderr:
+			if _, err := stderr.Write(msg[1:]); err != nil {
+				return err
+			}
+		case ChannelError:
+			return statusError(msg[1:])
+		default:
+			s.options.Log.Debugf("Ignoring message on channel %d", msg[0])
+		}
+	}
+}
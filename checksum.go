@@ -0,0 +1,144 @@
+package support
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// Frame types of the checksum layer.
+const (
+	checksumData byte = 0
+	checksumEnd  byte = 1
+)
+
+const (
+	checksumHeaderLen    = 9 // type, length, CRC32
+	checksumMaxFrameSize = 32 * 1024
+)
+
+var (
+	ErrChecksumMismatch = errors.New("Stream checksum mismatch")
+	ErrStreamTruncated  = errors.New("Stream truncated")
+)
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumConn frames all data with a CRC32 per frame and a SHA-256 of all data at the end of the stream.
+type checksumConn struct {
+	conn io.ReadWriteCloser
+
+	writeMutex sync.Mutex
+	writeHash  hash.Hash
+
+	readHash hash.Hash
+	pending  []byte
+	ended    bool
+}
+
+// NewChecksumConn wraps the given hijacked connection, so corruption of the data read is reported as
+// ErrChecksumMismatch and a stream ending without the final checksum is reported as ErrStreamTruncated.
+// The end of the written data must be signalled using CloseWrite (see CloseStreams). Both sides must use it.
+func NewChecksumConn(conn io.ReadWriteCloser) io.ReadWriteCloser {
+	return &checksumConn{
+		conn:      conn,
+		writeHash: sha256.New(),
+		readHash:  sha256.New(),
+	}
+}
+
+func (c *checksumConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if c.ended {
+			return 0, io.EOF
+		}
+		header := make([]byte, checksumHeaderLen)
+		if _, err := io.ReadFull(c.conn, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return 0, ErrStreamTruncated
+			}
+			return 0, err
+		}
+		size := int(binary.BigEndian.Uint32(header[1:5]))
+		if size > checksumMaxFrameSize {
+			return 0, ErrChecksumMismatch
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(c.conn, payload); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return 0, ErrStreamTruncated
+			}
+			return 0, err
+		}
+		if crc32.Checksum(payload, checksumTable) != binary.BigEndian.Uint32(header[5:]) {
+			return 0, ErrChecksumMismatch
+		}
+		switch header[0] {
+		case checksumData:
+			c.readHash.Write(payload)
+			c.pending = payload
+		case checksumEnd:
+			if !bytes.Equal(payload, c.readHash.Sum(nil)) {
+				return 0, ErrChecksumMismatch
+			}
+			c.ended = true
+		default:
+			return 0, ErrChecksumMismatch
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// writeFrame writes a single frame, the write mutex must be held.
+func (c *checksumConn) writeFrame(frameType byte, payload []byte) error {
+	frame := make([]byte, checksumHeaderLen+len(payload))
+	frame[0] = frameType
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[5:], crc32.Checksum(payload, checksumTable))
+	copy(frame[checksumHeaderLen:], payload)
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *checksumConn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > checksumMaxFrameSize {
+			n = checksumMaxFrameSize
+		}
+		if err := c.writeFrame(checksumData, p[:n]); err != nil {
+			return written, err
+		}
+		c.writeHash.Write(p[:n])
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// CloseWrite sends the final checksum and closes the write side of the connection if supported.
+func (c *checksumConn) CloseWrite() error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if err := c.writeFrame(checksumEnd, c.writeHash.Sum(nil)); err != nil {
+		return err
+	}
+	if cw, ok := c.conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func (c *checksumConn) Close() error {
+	return c.conn.Close()
+}
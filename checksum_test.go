@@ -0,0 +1,14 @@
+package support
+
+import (
+	"io"
+	"testing"
+)
+
+func TestChecksumRoundTrip(t *testing.T) {
+	srv := echoServer(t, nil, func(conn io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+		return NewChecksumConn(conn), nil
+	})
+	defer srv.Close()
+	streamRoundTrip(t, srv, HijackHttpOptions{Checksum: true})
+}
@@ -2,7 +2,8 @@ package support
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,50 +11,143 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	neturl "net/url"
+	"os"
 	"strings"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
 
 	docker "github.com/giantswarm/hijack-stream-support/docker"
 )
 
 type HijackHttpOptions struct {
-	Method             string
-	Url                string
-	Host               string // If set, this will be passed as `Host` header to the request.
-	DockerTermProtocol bool
-	InputStream        io.Reader
-	ErrorStream        io.Writer
-	OutputStream       io.Writer
-	Data               interface{}
-	Header             http.Header
-	Log                docker.Logger
-	ErrorHandler       func(res *http.Response, err error) error
+	Method              string
+	Url                 string
+	Host                string // If set, this will be passed as `Host` header to the request.
+	DockerTermProtocol  bool
+	InputStream         io.Reader
+	ErrorStream         io.Writer
+	OutputStream        io.Writer
+	Data                interface{}
+	Header              http.Header
+	Log                 docker.Logger // If set, diagnostic messages are logged to this logger, which may implement LeveledLogger.
+	ErrorHandler        func(res *http.Response, err error) error
+	ConnectProtocol     string                      // If set, the stream is established using an HTTP/2 extended CONNECT (RFC 8441) with this `:protocol` instead of an HTTP/1.1 upgrade. The connection is dialed like any other (including ProxyProtocol and registered transports) and the stream options (Compression, Encryption, Checksum, ...) apply to the stream.
+	ChannelProtocol     bool                        // If set, streams are (de)multiplexed using the channel byte prefix of the v4.channel.k8s.io protocol (websocket URLs only).
+	NegotiateVersion    bool                        // If set, the daemon is pinged first and the request path is prefixed with the API version it reports (/v1.xx).
+	ResizeFunc          ResizeFunc                  // If set, this function is used to resize the TTY of the remote process when Session.Resize is called.
+	SignalFunc          SignalFunc                  // If set, this function is used to forward signals to the remote process when Session.Signal is called.
+	RawTerminal         *os.File                    // If set and a terminal, it is put into raw mode while streaming and restored afterwards.
+	Streams             map[uint8]io.Writer         // Additional streams of the multiplexed format (DockerTermProtocol) keyed by their fd number, e.g. 3 for control messages.
+	JSONMessageHandler  func(msg JSONMessage) error // If set, the output stream is parsed as newline-delimited JSON messages (e.g. docker build progress) passed to this handler. Embedded errors are returned as error. The output is still written to the OutputStream (if any), recorder and TeeOutput.
+	StderrAsError       bool                        // If set, everything received on the error stream is collected and returned as *StderrError when streaming has finished.
+	CombineOutput       bool                        // If set and ErrorStream is nil, the error stream is written to OutputStream instead of being discarded.
+	MaxFrameSize        int                         // Maximum size of a multiplexed frame (DockerTermProtocol), defaults to 16MB.
+	ExitCodeFrame       bool                        // If set (together with DockerTermProtocol), the server is asked to send the exit code of the remote process at the end of the stream. A non-zero exit code is returned as *ExitError.
+	Compression         []string                    // If set, these stream encodings (gzip, zstd) are offered to the server in order of preference. The payload is compressed with the one it selects in its response.
+	EncryptionKey       *[32]byte                   // If set, the payload is encrypted using NaCl secretbox with this pre-shared key. The server must use NewEncryptedConn with the same key (and client false).
+	EncryptionHandshake bool                        // If set, the encryption key is derived from an X25519 key exchange (see HandshakeEncryptionKey), mixed with EncryptionKey if set.
+	Checksum            bool                        // If set, every frame of the payload is protected by a CRC32 and a SHA-256 of all data is verified at the end, reporting corruption or truncation as error. The server must use NewChecksumConn.
+	FlowControlWindow   int                         // If set, a credit-based flow control layer with this window (in bytes) is used over the hijacked connection. The server must use NewFlowControlConn with the same window.
+	Multiplex           bool                        // If set, a yamux session is run over the hijacked connection instead of streaming, use Session.OpenStream and Session.AcceptStream to exchange logical streams.
+	MuxConfig           *yamux.Config               // Configuration of the yamux session in Multiplex mode, defaults are used if nil.
+	SpdyProtocols       []string                    // If set, the connection is upgraded to SPDY/3.1 offering these stream protocols (e.g. `v4.channel.k8s.io`) and every stream is transferred over a separate SPDY stream.
+	ProxyProtocol       int                         // If set (1 or 2), a PROXY protocol header of this version announcing the connection is sent right after dialing (before the TLS handshake), as required by some L4 proxies.
+	Username            string                      // If set (or Password), the request is sent with these HTTP basic auth credentials.
+	Password            string
+	TokenProvider       TokenProvider      // If set, this function is called for every request to get the bearer token it is sent with, so short-lived tokens are refreshed.
+	TokenSource         oauth2.TokenSource // If set, the request is sent with a token of this OAuth2 token source, which refreshes it when expired (e.g. of oauth2.Config.TokenSource).
+	Jar                 http.CookieJar     // If set, the cookies of this jar (e.g. of a prior login request) are sent with the request and the cookies set by its response are stored in it.
+	SigV4               *SigV4Options      // If set, the request is signed using AWS Signature Version 4 with these options.
+	DigestAuth          bool               // If set, the Username and Password are sent using digest auth (RFC 7616) instead of basic auth: a 401 digest challenge of the server is answered by sending the request again with credentials (HTTP/1.1 only).
+	Negotiate           NegotiateProvider  // If set, the request is sent with a SPNEGO (Negotiate) token of this provider, e.g. for Kerberos-protected gateways.
+	RequestID           string             // If set, this request (correlation) ID is sent in the X-Request-Id header, prefixed to all log messages and included in the errors of the session (see RequestError).
+	GenerateRequestID   bool               // If set and RequestID is empty, a random request ID is generated. Use Session.RequestID to get it.
+	Tracer              trace.Tracer       // If set, dialing, the TLS and HTTP handshakes and the stream are traced with OpenTelemetry spans of this tracer and the trace context is sent with the request.
+	TraceContext        context.Context    // Parent context of the spans of the Tracer, e.g. with the span of the operation starting the session.
+	Metrics             *Metrics           // If set, Prometheus metrics of the session are recorded in these metrics (see NewMetrics).
+	Stats               StatsRecorder      // If set, statistics of the session are passed to this recorder, to feed other metrics or telemetry systems. In Multiplex mode, the bytes of the hijacked connection are counted.
+	TraceWriter         io.Writer          // If set, a hex/ASCII dump of all data read and written on the connection (including the handshake) is written to this writer with direction and timestamp, for debugging protocol mismatches.
+	DumpHandshake       bool               // If set, the HTTP handshake request (with credentials redacted) and the response head (with cookies redacted) are dumped to the logger at debug level, or to HandshakeWriter if set. WebSocket handshakes are not dumped.
+	HandshakeWriter     io.Writer          // If set with DumpHandshake, the handshake is dumped to this writer instead of the logger.
+	RecordWriter        io.Writer          // If set, the streams are recorded with their timing into this writer as asciinema v2 cast file (see CastRecorder), e.g. for audits. The terminal size is the one of RawTerminal (80x24 without).
+	Recorder            Recorder           // If set, the streams are recorded with this recorder instead of the RecordWriter, e.g. of NewFileRecorder. Recording (also with RecordWriter) is supported in all streaming modes (raw, DockerTermProtocol, SpdyProtocols, ChannelProtocol and ConnectProtocol) but not with Multiplex, which fails with ErrMultiplexOption.
+	Redact              Redactor           // If set, recorded and teed data is passed through this redactor line by line (e.g. of RedactPatterns), so secrets echoed in terminals do not end up in recordings or logs.
+	TeeOutput           io.Writer          // If set, the output and error streams are mirrored to this writer (e.g. a log file). Its failures are logged and do not affect the streams. Supported in all streaming modes but not with Multiplex, which fails with ErrMultiplexOption.
+	TeeInput            io.Writer          // If set, the input stream is mirrored to this writer. Its failures are logged and do not affect the streams. Supported in all streaming modes but not with Multiplex, which fails with ErrMultiplexOption.
+	TransformOutput     OutputTransform    // If set, the output and error streams are written through the writers returned by this function, e.g. for filtering, rewriting or protocol shims. Recordings and teed streams get the data as received. Supported in all streaming modes but not with Multiplex, which fails with ErrMultiplexOption.
+	TransformInput      InputTransform     // If set, the input stream is read through the reader returned by this function. Recordings and teed streams get the data as read from InputStream. Supported in all streaming modes but not with Multiplex, which fails with ErrMultiplexOption.
+
+	// Lifecycle callbacks, e.g. to drive UI state like spinners or reconnect prompts. They are called from the
+	// goroutines of the session and should not block.
+	OnConnect  func(s *Session)            // If set, called whenever a connection to the server has been established (after the TLS handshake), except for the connection retrying a digest auth challenge.
+	OnUpgraded func(s *Session)            // If set, called once the connection has been hijacked.
+	OnInputEOF func(s *Session)            // If set, called once the InputStream has been sent completely and the input has been closed.
+	OnClose    func(s *Session, err error) // If set, called once the session has ended with its final error (nil if successful). For DialHijack, it is only called if the request fails.
+
+	prepared bool // Set once the options have been prepared by prepareOptions
 }
 
+// TokenProvider returns the current bearer token for a request, e.g. refreshing a short-lived JWT when needed.
+type TokenProvider func(ctx context.Context) (string, error)
+
+// NegotiateProvider returns the initial SPNEGO token for the given service principal name (e.g. HTTP/host.example.com),
+// e.g. using a Kerberos library or the GSSAPI/SSPI of the system.
+type NegotiateProvider func(spn string) ([]byte, error)
+
 var (
-	ErrMissingMethod = errors.New("Method not set")
-	ErrMissingUrl    = errors.New("Url not set")
+	ErrMissingMethod     = errors.New("Method not set")
+	ErrMissingUrl        = errors.New("Url not set")
+	ErrNotHijacked       = errors.New("Connection not hijacked")
+	ErrDetachUnsupported = errors.New("Connection cannot be detached for this protocol")
+	ErrMultiplexOption   = errors.New("Option not supported in Multiplex mode")
 )
 
 // HijackHttpRequest performs an HTTP  request with given method, url and data and hijacks the request (after a successful connection) to stream
 // data from/to the given input, output and error streams.
 func HijackHttpRequest(options HijackHttpOptions) error {
-	if options.Log == nil {
-		// Make sure there is always a logger
-		options.Log = &logIgnore{}
+	s, err := StartHijackHttpRequest(options)
+	if err != nil {
+		return err
 	}
-	if options.Method == "" {
-		return ErrMissingMethod
+	defer s.RestoreTerminal()
+	return s.Wait()
+}
+
+// StartHijackHttpRequest performs the HTTP request like HijackHttpRequest, but returns as soon as the connection
+// has been hijacked. Data is streamed in the background, use the returned session to control the stream
+// and wait for it to finish.
+func StartHijackHttpRequest(options HijackHttpOptions) (*Session, error) {
+	options, err := prepareOptions(options)
+	if err != nil {
+		return nil, err
 	}
-	if options.Url == "" {
-		return ErrMissingUrl
+	s := newSession(options)
+	if err := s.start(func() error { return hijackRequest(s) }); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// hijackRequest performs the request described by the options of the given session and streams data.
+func hijackRequest(s *Session) error {
+	options := s.options
+	var err error
+	if options.NegotiateVersion {
+		if options.Url, err = negotiateURL(options.Url, options.Header); err != nil {
+			return err
+		}
+		s.options = options
 	}
 
 	req, err := createHijackHttpRequest(options)
 	if err != nil {
 		return err
 	}
+	s.injectTrace(req.Header)
 
 	// Parse URL for endpoint data
 	ep, err := neturl.Parse(options.Url)
@@ -61,44 +155,198 @@ func HijackHttpRequest(options HijackHttpOptions) error {
 		return err
 	}
 
-	protocol := ep.Scheme
-	address := ep.Path
-	if protocol != "unix" {
-		protocol = "tcp"
-		address = ep.Host
-		if !strings.Contains(address, ":") {
-			if ep.Scheme == "https" {
-				address = address + ":443"
-			} else {
-				address = address + ":80"
-			}
-		}
+	if isWebSocket(ep) {
+		return hijackWebSocket(s, ep)
+	}
+	if s.detach && (options.ConnectProtocol != "" || len(options.SpdyProtocols) > 0) {
+		return ErrDetachUnsupported
+	}
+	if options.ChannelProtocol {
+		return ErrChannelProtocolWithoutWebSocket
+	}
+	if options.ConnectProtocol != "" {
+		return hijackExtendedConnect(s, ep)
 	}
 
 	// Dial the server
-	var dial net.Conn
-	//fmt.Printf("Dialing %s %s\n", protocol, address)
-	if ep.Scheme == "https" {
-		config := &tls.Config{}
-		dial, err = docker.TLSDial(protocol, address, config)
+	conn, err := dialSession(s, ep)
+	if err != nil {
+		return err
+	}
+
+	return hijackConn(s, conn, req, func() (net.Conn, error) { return redialSession(s, ep) })
+}
+
+// DialHijack performs the HTTP request described by the given options and returns the hijacked connection
+// instead of streaming data from/to the streams of the options, so it can be handed to any library that expects
+// a net.Conn. Data the server sent right after its response is returned by the first reads of the connection.
+// For websocket URLs, the returned connection transfers binary messages.
+func DialHijack(options HijackHttpOptions) (net.Conn, error) {
+	options, err := prepareOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	s := newSession(options)
+	s.detach = true
+	err = hijackRequest(s)
+	s.endTrace(err)
+	options.Metrics.sessionEnded(s, err)
+	s.statsClosed(err)
+	if err != nil {
+		err = s.wrapError(err)
+		if options.OnClose != nil {
+			options.OnClose(s, err)
+		}
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.detached == nil {
+		// The ErrorHandler accepted a failed request
+		return nil, s.wrapError(ErrNotHijacked)
+	}
+	return s.detached, nil
+}
+
+// HijackHttpConn performs the HTTP request described by the given options over an already established
+// connection and hijacks it (after a successful response) to stream data from/to the given input, output
+// and error streams. The connection is closed when streaming has finished.
+// This is useful for connections that are tunneled or dialed by the caller.
+func HijackHttpConn(conn io.ReadWriteCloser, options HijackHttpOptions) error {
+	options, err := prepareOptions(options)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	req, err := createHijackHttpRequest(options)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	s := newSession(options)
+	s.injectTrace(req.Header)
+	s.connected()
+	return s.finish(hijackConn(s, newDumpConn(newNetConn(conn), options.TraceWriter), req, nil))
+}
+
+// prepareOptions validates the given options and fills in defaults.
+func prepareOptions(options HijackHttpOptions) (HijackHttpOptions, error) {
+	if options.Log == nil {
+		// Make sure there is always a logger
+		options.Log = &logIgnore{}
+	}
+	if options.Method == "" {
+		return options, ErrMissingMethod
+	}
+	if options.Url == "" {
+		return options, ErrMissingUrl
+	}
+	if err := checkMultiplexOptions(options); err != nil {
+		return options, err
+	}
+	if !options.prepared {
+		// Options of forwarded connections are prepared again, the logger must only be wrapped once
+		options.Log = withAttrs(options.Log, "endpoint", redactedURL(options.Url))
+		if err := applyRequestID(&options); err != nil {
+			return options, err
+		}
+		options.prepared = true
+	}
+	if options.SigV4 != nil {
+		if err := options.SigV4.validate(); err != nil {
+			return options, err
+		}
+	}
+	if !options.DigestAuth && (options.Username != "" || options.Password != "") {
+		credentials := base64.StdEncoding.EncodeToString([]byte(options.Username + ":" + options.Password))
+		options.setHeader("Authorization", "Basic "+credentials)
+	}
+	if options.TokenProvider != nil {
+		token, err := options.TokenProvider(context.Background())
 		if err != nil {
-			fmt.Printf("TLS Dialing %s %s failed %#v\n", protocol, address, err)
-			return err
+			return options, err
 		}
-	} else {
-		dial, err = net.Dial(protocol, address)
+		options.setHeader("Authorization", "Bearer "+token)
+	}
+	if options.TokenSource != nil {
+		token, err := options.TokenSource.Token()
 		if err != nil {
-			fmt.Printf("Dialing %s %s failed %#v\n", protocol, address, err)
-			return err
+			return options, err
+		}
+		options.setHeader("Authorization", token.Type()+" "+token.AccessToken)
+	}
+	if options.Negotiate != nil {
+		ep, err := neturl.Parse(options.Url)
+		if err != nil {
+			return options, err
+		}
+		host := ep.Hostname()
+		if options.Host != "" {
+			host = options.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+		}
+		token, err := options.Negotiate("HTTP/" + host)
+		if err != nil {
+			return options, err
 		}
+		options.setHeader("Authorization", "Negotiate "+base64.StdEncoding.EncodeToString(token))
+	}
+	return options, nil
+}
+
+// checkMultiplexOptions returns ErrMultiplexOption if the given options use Multiplex together with an option acting
+// on the input, output and error streams, which are not used in Multiplex mode.
+func checkMultiplexOptions(options HijackHttpOptions) error {
+	if !options.Multiplex {
+		return nil
+	}
+	unsupported := []struct {
+		name string
+		set  bool
+	}{
+		{"Recorder", options.Recorder != nil || options.RecordWriter != nil},
+		{"TeeOutput", options.TeeOutput != nil},
+		{"TeeInput", options.TeeInput != nil},
+		{"TransformOutput", options.TransformOutput != nil},
+		{"TransformInput", options.TransformInput != nil},
+	}
+	for _, option := range unsupported {
+		if option.set {
+			return fmt.Errorf("%w: %s", ErrMultiplexOption, option.name)
+		}
+	}
+	return nil
+}
+
+// setHeader sets the given header of the request without modifying the Header of the caller.
+func (options *HijackHttpOptions) setHeader(key, value string) {
+	header := options.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set(key, value)
+	options.Header = header
+}
+
+// hijackConn sends the given request over the given connection, hijacks the connection
+// and streams data from/to the streams in the options of the given session.
+// If set, redial is used to connect again if the request has to be retried (see sendRequest).
+func hijackConn(s *Session, conn net.Conn, req *http.Request, redial func() (net.Conn, error)) error {
+	options := s.options
+	if len(options.SpdyProtocols) > 0 {
+		return hijackSpdy(s, conn, req, redial)
 	}
 
 	// Start initial HTTP connection
-	clientconn := httputil.NewClientConn(dial, nil)
+	span := s.startSpan(SpanHTTPHandshake)
+	clientconn, res, err := sendRequest(options, conn, req, redial)
+	s.endHandshakeSpan(span, res, err)
 	defer clientconn.Close()
-
-	res, err := clientconn.Do(req)
-	if res.StatusCode > 299 || err != nil {
+	if err != nil || res.StatusCode > 299 {
 		if options.ErrorHandler != nil {
 			if err := options.ErrorHandler(res, err); err != nil {
 				return err
@@ -111,27 +359,68 @@ func HijackHttpRequest(options HijackHttpOptions) error {
 
 	// Hijack HTTP connection
 	rwc, br := clientconn.Hijack()
+	if s.detach {
+		s.detachConn(&bufferedConn{rwc, br})
+		return nil
+	}
 	defer rwc.Close()
+	return streamUpgraded(s, &bufferedConn{rwc, br}, res)
+}
+
+// streamUpgraded streams data over the given upgraded stream of the given session, applying the stream options
+// (encryption, compression selected by the given response, checksums, multiplexing and flow control) to it.
+func streamUpgraded(s *Session, stream io.ReadWriteCloser, res *http.Response) (err error) {
+	options := s.options
+	if options.EncryptionKey != nil || options.EncryptionHandshake {
+		key := options.EncryptionKey
+		if options.EncryptionHandshake {
+			if key, err = HandshakeEncryptionKey(stream, key); err != nil {
+				return err
+			}
+		}
+		stream = NewEncryptedConn(stream, key, true)
+	}
+	if encoding := res.Header.Get(CompressionHeader); encoding != "" {
+		if stream, err = NewCompressedConn(stream, encoding); err != nil {
+			return err
+		}
+	}
+	if options.Checksum {
+		stream = NewChecksumConn(stream)
+	}
+	if options.Multiplex {
+		return streamMux(s, stream)
+	}
+	if options.FlowControlWindow > 0 {
+		stream = NewFlowControlConn(stream, options.FlowControlWindow)
+	}
+	s.upgrade(stream, stream)
 
 	// Stream data
-	return streamData(rwc, br, options)
+	return streamData(s, stream, stream)
 }
 
 // createHijackHttpRequest creates an upgradable HTTP request according to the given options
 func createHijackHttpRequest(options HijackHttpOptions) (*http.Request, error) {
 	var params io.Reader
+	var body []byte
 	if options.Data != nil {
-		buf, err := json.Marshal(options.Data)
-		if err != nil {
+		var err error
+		if body, err = json.Marshal(options.Data); err != nil {
 			return nil, err
 		}
-		params = bytes.NewBuffer(buf)
+		params = bytes.NewBuffer(body)
 	}
 
 	req, err := http.NewRequest(options.Method, options.Url, params)
 	if err != nil {
 		return nil, err
 	}
+	if req.URL.Scheme == "unix" {
+		// Only the part of the path following the socket is requested
+		_, req.URL.Path = splitUnixPath(req.URL.Path)
+		req.URL.RawPath = ""
+	}
 	if options.Header != nil {
 		for k, values := range options.Header {
 			req.Header.Del(k)
@@ -140,59 +429,244 @@ func createHijackHttpRequest(options HijackHttpOptions) (*http.Request, error) {
 			}
 		}
 	}
-	req.Header.Set("Content-Type", "text/plain")
+	if options.Jar != nil {
+		addCookies(req.Header, options.Jar, req.URL)
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "text/plain")
+	}
 	req.Header.Set("Connection", "Upgrade")
 	req.Header.Set("Upgrade", "tcp")
 	if options.Host != "" {
 		req.Host = options.Host
 	}
+	if options.ExitCodeFrame {
+		req.Header.Set(ExitCodeHeader, "1")
+	}
+	if len(options.Compression) > 0 {
+		req.Header.Set(AcceptCompressionHeader, strings.Join(options.Compression, ", "))
+	}
+	if options.SigV4 != nil {
+		options.SigV4.sign(req.Header, req.Method, req.URL, requestHost(req), body, time.Now())
+	}
 	return req, nil
 }
 
+// requestHost returns the host the given request is sent with.
+func requestHost(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+	return req.URL.Host
+}
+
+// addCookies adds the cookies of the given jar for the given URL to the Cookie header of a request.
+func addCookies(header http.Header, jar http.CookieJar, u *neturl.URL) {
+	var cookies []string
+	if prior := header.Get("Cookie"); prior != "" {
+		cookies = append(cookies, prior)
+	}
+	for _, cookie := range jar.Cookies(cookieURL(u)) {
+		cookies = append(cookies, (&http.Cookie{Name: cookie.Name, Value: cookie.Value}).String())
+	}
+	if len(cookies) > 0 {
+		header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+}
+
+// storeCookies stores the cookies set by the given response to the given request in the Jar of the options.
+func storeCookies(options HijackHttpOptions, req *http.Request, res *http.Response) {
+	if options.Jar == nil || res == nil {
+		return
+	}
+	if cookies := res.Cookies(); len(cookies) > 0 {
+		options.Jar.SetCookies(cookieURL(req.URL), cookies)
+	}
+}
+
+// redactedURL returns the given URL with the password of its user info redacted, so it can be logged or traced.
+func redactedURL(rawurl string) string {
+	u, err := neturl.Parse(rawurl)
+	if err != nil {
+		// Unparsable URLs are not reported, they might still contain credentials
+		return "invalid URL"
+	}
+	return u.Redacted()
+}
+
+// cookieURL returns the given URL with the websocket schemes mapped to their HTTP schemes, since cookie jars
+// ignore URLs of other schemes.
+func cookieURL(u *neturl.URL) *neturl.URL {
+	var scheme string
+	switch u.Scheme {
+	case "ws":
+		scheme = "http"
+	case "wss":
+		scheme = "https"
+	default:
+		return u
+	}
+	mapped := *u
+	mapped.Scheme = scheme
+	return &mapped
+}
+
+// outputStreams returns the writers for the output and error streams of the given options.
+func outputStreams(options HijackHttpOptions) (stdout, stderr io.Writer) {
+	stdout = options.OutputStream
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	stderr = options.ErrorStream
+	if stderr == nil {
+		if options.CombineOutput {
+			stderr = stdout
+		} else {
+			stderr = ioutil.Discard
+		}
+	}
+	return stdout, stderr
+}
+
+// outputWriters returns the output and error streams of the given options as written in all streaming modes: through
+// the writers of TransformOutput, mirrored to the given recorder (if any) and TeeOutput. The returned function closes
+// the writers of TransformOutput and flushes TeeOutput once the output has ended.
+func outputWriters(options HijackHttpOptions, recorder Recorder) (stdout, stderr io.Writer, closeOutput func() error) {
+	stdout, stderr = outputStreams(options)
+	stdout, stderr, closeTransform := transformOutput(options, stdout, stderr)
+	if recorder != nil {
+		output := recordWriter(recorder.WriteOutput)
+		stdout, stderr = io.MultiWriter(stdout, output), io.MultiWriter(stderr, output)
+	}
+	tee := newTeeWriter(options, options.TeeOutput, "TeeOutput")
+	if tee != nil {
+		stdout, stderr = io.MultiWriter(stdout, tee), io.MultiWriter(stderr, tee)
+	}
+	return stdout, stderr, func() error {
+		err := closeTransform()
+		if tee != nil {
+			tee.Flush()
+		}
+		return err
+	}
+}
+
+// inputReader returns the input stream of the given options as read in all streaming modes: mirrored to the given
+// recorder (if any) and TeeInput, read through TransformInput. The returned function flushes TeeInput once the input
+// has ended. The reader is nil without InputStream.
+func inputReader(options HijackHttpOptions, recorder Recorder) (in io.Reader, closeInput func()) {
+	in = options.InputStream
+	if in == nil {
+		return nil, func() {}
+	}
+	if recorder != nil {
+		in = io.TeeReader(in, recordWriter(recorder.WriteInput))
+	}
+	tee := newTeeWriter(options, options.TeeInput, "TeeInput")
+	if tee != nil {
+		in = io.TeeReader(in, tee)
+	}
+	if options.TransformInput != nil {
+		in = options.TransformInput(in)
+	}
+	return in, func() {
+		if tee != nil {
+			tee.Flush()
+		}
+	}
+}
+
 // streamData copies both input/output/error streams to/from the hijacked streams
-func streamData(rwc io.Writer, br io.Reader, options HijackHttpOptions) error {
+func streamData(s *Session, rwc io.Writer, br io.Reader) error {
+	options := s.options
 	errsIn := make(chan error, 1)
 	errsOut := make(chan error, 1)
 	exit := make(chan bool)
+	br = s.countReader(&firstByteReader{Reader: br, s: s, upgraded: time.Now()}, &s.bytesIn, DirectionIn)
+	recorder, err := s.startRecording()
+	if err != nil {
+		return err
+	}
+	if recorder != nil {
+		defer recorder.Close()
+	}
 
 	go func() {
 		defer close(exit)
 		defer close(errsOut)
 		var err error
-		stdout := options.OutputStream
-		if stdout == nil {
-			stdout = ioutil.Discard
-		}
-		stderr := options.ErrorStream
-		if stderr == nil {
-			stderr = ioutil.Discard
+		stdout, stderr, closeOutput := outputWriters(options, recorder)
+		var messages *jsonMessageWriter
+		if options.JSONMessageHandler != nil {
+			messages = newJSONMessageWriter(options.JSONMessageHandler)
+			stdout = io.MultiWriter(stdout, messages)
 		}
+		var n int64
 		if !options.DockerTermProtocol {
 			// When TTY is ON, use regular copy
-			_, err = io.Copy(stdout, br)
+			n, err = io.Copy(stdout, br)
 		} else {
-			_, err = docker.StdCopy(stdout, stderr, br, options.Log)
+			var exitFrame bytes.Buffer
+			streams := map[uint8]io.Writer{
+				byte(StdinStream):  stdout,
+				byte(StdoutStream): stdout,
+				byte(StderrStream): stderr,
+			}
+			if options.ExitCodeFrame {
+				streams[docker.Exit[0]] = &exitFrame
+			}
+			for fd, w := range options.Streams {
+				streams[fd] = w
+			}
+			maxFrameSize := options.MaxFrameSize
+			if maxFrameSize == 0 {
+				maxFrameSize = docker.DefaultMaxFrameSize
+			}
+			if n, err = docker.StdCopyStreamsLimit(streams, br, maxFrameSize, options.Log); err == nil {
+				err = s.setExitStatus(exitFrame.Bytes())
+			}
+		}
+		if messages != nil {
+			if merr := messages.Close(); merr != nil {
+				err = merr
+			}
+		}
+		if cerr := closeOutput(); err == nil {
+			err = cerr
 		}
+		withAttrs(options.Log, "direction", DirectionIn, "bytes", n).Debugf("Output stream finished after %d bytes", n)
 		errsOut <- err
 	}()
 	go func() {
 		defer close(errsIn)
 		var err error
-		in := options.InputStream
+		in, closeInput := inputReader(options, recorder)
 		if in != nil {
-			_, err = io.Copy(rwc, in)
+			var n int64
+			n, err = io.Copy(rwc, s.countReader(in, &s.bytesOut, DirectionOut))
+			withAttrs(options.Log, "direction", DirectionOut, "bytes", n).Debugf("Input stream finished after %d bytes", n)
+			closeInput()
 		}
-		if err := rwc.(closeWriter).CloseWrite(); err != nil {
-			options.Log.Debugf("CloseWrite failed %#v", err)
+		if cw, ok := rwc.(closeWriter); ok {
+			if err := cw.CloseWrite(); err != nil {
+				levels(options.Log).Warnf("CloseWrite failed %#v", err)
+			}
+		}
+		if in != nil && err == nil && options.OnInputEOF != nil {
+			options.OnInputEOF(s)
 		}
 		errsIn <- err
 	}()
 	<-exit
-	select {
-	case err := <-errsOut:
+	// Errors of the output stream take precedence, the input stream may still be blocked reading
+	if err := <-errsOut; err != nil {
 		return err
+	}
+	select {
 	case err := <-errsIn:
 		return err
+	default:
+		return nil
 	}
 }
 
@@ -212,3 +686,12 @@ type logIgnore struct {
 func (this *logIgnore) Debugf(msg string, args ...interface{}) {
 	// Ignore the log message
 }
+
+func (this *logIgnore) Infof(msg string, args ...interface{}) {
+}
+
+func (this *logIgnore) Warnf(msg string, args ...interface{}) {
+}
+
+func (this *logIgnore) Errorf(msg string, args ...interface{}) {
+}
@@ -0,0 +1,59 @@
+package support
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// echoServer returns a server echoing the hijacked connection, wrapped by the given function, until the client
+// closes its input. The given header is sent in the upgrade response.
+func echoServer(t *testing.T, header http.Header, wrap func(conn io.ReadWriteCloser) (io.ReadWriteCloser, error)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		in, _, err := HijackServerResponse(w, http.StatusSwitchingProtocols, header)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn, err := wrap(in.(net.Conn))
+		if err != nil {
+			in.Close()
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		if _, err := io.Copy(conn, conn); err != nil {
+			t.Error(err)
+		}
+		if cw, ok := conn.(closeWriter); ok {
+			cw.CloseWrite()
+		}
+	}))
+}
+
+// streamRoundTrip streams a payload to the given echo server with the given options and checks it is received back.
+func streamRoundTrip(t *testing.T, srv *httptest.Server, options HijackHttpOptions) {
+	data := bytes.Repeat([]byte("hijacked stream "), 20000)
+	var out bytes.Buffer
+	options.Method = "POST"
+	options.Url = srv.URL
+	options.InputStream = bytes.NewReader(data)
+	options.OutputStream = &out
+	if err := HijackHttpRequest(options); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("received %d of %d bytes", out.Len(), len(data))
+	}
+}
+
+func TestRawRoundTrip(t *testing.T) {
+	srv := echoServer(t, nil, func(conn io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+		return conn, nil
+	})
+	defer srv.Close()
+	streamRoundTrip(t, srv, HijackHttpOptions{})
+}
@@ -0,0 +1,125 @@
+package support
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os/exec"
+)
+
+// CommandFunc creates the command to run for the given request.
+type CommandFunc func(req *http.Request) (*exec.Cmd, error)
+
+// CommandHandler returns an http.Handler that runs the command created by newCmd for every request and connects
+// its stdin, stdout and stderr to the hijacked streams, see HijackHandler. With the `tty` query parameter set,
+// the command is attached to a PTY like with PTYHandler. If newCmd fails, the request is answered
+// with 400 Bad Request. The process is killed when the connection drops or Shutdown is called, and its exit code
+// is sent to clients asking for it (see ExitCodeFrame).
+func CommandHandler(newCmd CommandFunc) http.Handler {
+	return CommandHandlerWithOptions(newCmd, CommandOptions{})
+}
+
+// CommandOptions harden the processes started by CommandHandlerWithOptions.
+type CommandOptions struct {
+	Env      []string                                     // If not nil, the process gets this environment ("KEY=value") instead of the environment of the server, plus the variables set by the CommandFunc.
+	Dir      string                                       // If set, the working directory of the process, overriding the one set by the CommandFunc.
+	User     string                                       // If set, the process runs as this user (name or numeric uid) with its primary group and supplementary groups, which requires privileges (not supported on windows).
+	Group    string                                       // If set, the process runs with this group (name or numeric gid) instead of the primary group of User.
+	Validate func(req *http.Request, cmd *exec.Cmd) error // If set, this function is called with the prepared command before it is started. An error rejects the request with 403 Forbidden.
+}
+
+// CommandHandlerWithOptions returns a CommandHandler that applies the given options to the commands created by newCmd.
+// Options that cannot be applied (e.g. an unknown user) are answered with 500 Internal Server Error.
+func CommandHandlerWithOptions(newCmd CommandFunc, options CommandOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		cmd, err := newCmd(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := options.apply(cmd); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if options.Validate != nil {
+			if err := options.Validate(req, cmd); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		StreamHandlerFunc(func(ctx context.Context, in io.Reader, out, errW io.Writer) error {
+			if StreamConfigFromContext(ctx).TTY {
+				return runPTY(ctx, cmd, in, out, func(ptyDevice) {})
+			}
+			return runCommand(ctx, cmd, in, out, errW)
+		}).ServeHTTP(w, req)
+	})
+}
+
+// apply applies the options to the given command.
+func (o CommandOptions) apply(cmd *exec.Cmd) error {
+	if o.Env != nil {
+		cmd.Env = append(append([]string{}, o.Env...), cmd.Env...)
+	}
+	if o.Dir != "" {
+		cmd.Dir = o.Dir
+	}
+	if o.User != "" || o.Group != "" {
+		return setCredential(cmd, o.User, o.Group)
+	}
+	return nil
+}
+
+// runCommand runs the given command with the given streams until it exits, killing it when the context is done
+// or the streams fail. A non-zero exit code is returned as *ExitError.
+func runCommand(ctx context.Context, cmd *exec.Cmd, in io.Reader, out, errW io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = &cancelWriter{out, cancel}
+	cmd.Stderr = &cancelWriter{errW, cancel}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// Not waited for, since reading the input may block beyond the end of the process
+	go func() {
+		_, err := io.Copy(stdin, in)
+		stdin.Close()
+		if err != nil {
+			// The connection dropped
+			cancel()
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		cmd.Process.Kill()
+	}()
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		status := processExitStatus(exitErr.ProcessState)
+		return &ExitError{Code: status.ExitCode, Signal: status.Signal}
+	}
+	return err
+}
+
+// cancelWriter calls cancel when writing fails.
+type cancelWriter struct {
+	w      io.Writer
+	cancel context.CancelFunc
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil {
+		w.cancel()
+	}
+	return n, err
+}
@@ -0,0 +1,163 @@
+package support
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Stream encodings supported for the compression of the hijacked payload.
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+const (
+	// AcceptCompressionHeader is the request header listing the stream encodings offered by the client, in order of preference.
+	AcceptCompressionHeader = "X-Hijack-Accept-Encoding"
+	// CompressionHeader is the response header containing the stream encoding selected by the server.
+	CompressionHeader = "X-Hijack-Encoding"
+)
+
+// maxDecoderMemory is the maximum memory the zstd decoder allocates, so a stream announcing a huge window cannot
+// exhaust the memory of the process.
+const maxDecoderMemory = 64 << 20
+
+var ErrUnsupportedCompression = errors.New("Unsupported stream encoding")
+
+// NegotiateCompression returns the first stream encoding offered by the client of the given request that is supported,
+// or an empty string if the payload must not be compressed. The server must send the result in the CompressionHeader
+// of its response and wrap the hijacked connection using NewCompressedConn.
+func NegotiateCompression(req *http.Request) string {
+	for _, value := range req.Header.Values(AcceptCompressionHeader) {
+		for _, encoding := range strings.Split(value, ",") {
+			switch encoding = strings.TrimSpace(encoding); encoding {
+			case CompressionGzip, CompressionZstd:
+				return encoding
+			}
+		}
+	}
+	return ""
+}
+
+// compressedConn compresses everything written to and decompresses everything read from a connection.
+type compressedConn struct {
+	conn     io.ReadWriteCloser
+	encoding string
+
+	writeMutex  sync.Mutex
+	writer      compressWriter
+	writeClosed bool // Set once the writer has been closed
+
+	readMutex  sync.Mutex // Held while reading, so the decompressor is not released during a read
+	reader     io.Reader
+	readClose  func()
+	readClosed bool // Set once the decompressor has been released
+}
+
+type compressWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// NewCompressedConn wraps the given hijacked connection, compressing written data with the given stream encoding
+// and decompressing read data. Every write is flushed, so interactive streams are not delayed.
+func NewCompressedConn(conn io.ReadWriteCloser, encoding string) (io.ReadWriteCloser, error) {
+	c := &compressedConn{conn: conn, encoding: encoding}
+	switch encoding {
+	case CompressionGzip:
+		c.writer = gzip.NewWriter(conn)
+	case CompressionZstd:
+		w, err := zstd.NewWriter(conn)
+		if err != nil {
+			return nil, err
+		}
+		c.writer = w
+	default:
+		return nil, ErrUnsupportedCompression
+	}
+	return c, nil
+}
+
+func (c *compressedConn) Read(p []byte) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	if c.readClosed {
+		return 0, io.ErrClosedPipe
+	}
+	if c.reader == nil {
+		// Created on the first read, since the decompressors read the stream header immediately
+		switch c.encoding {
+		case CompressionGzip:
+			r, err := gzip.NewReader(c.conn)
+			if err != nil {
+				return 0, err
+			}
+			c.reader, c.readClose = r, func() { r.Close() }
+		case CompressionZstd:
+			r, err := zstd.NewReader(c.conn, zstd.WithDecoderMaxMemory(maxDecoderMemory))
+			if err != nil {
+				return 0, err
+			}
+			c.reader, c.readClose = r, r.Close
+		}
+	}
+	return c.reader.Read(p)
+}
+
+func (c *compressedConn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if c.writeClosed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := c.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.writer.Flush()
+}
+
+// CloseWrite finishes the compressed stream and closes the write side of the connection if supported.
+func (c *compressedConn) CloseWrite() error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if err := c.closeWriter(); err != nil {
+		return err
+	}
+	if cw, ok := c.conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// closeWriter closes the compressor once, releasing its resources (e.g. the goroutines of zstd).
+// The write mutex must be held.
+func (c *compressedConn) closeWriter() error {
+	if c.writeClosed {
+		return nil
+	}
+	c.writeClosed = true
+	return c.writer.Close()
+}
+
+// Close closes the connection and releases the compressor and decompressor. The connection is closed first, so
+// pending reads and writes return before their resources are released.
+func (c *compressedConn) Close() error {
+	err := c.conn.Close()
+	c.writeMutex.Lock()
+	c.closeWriter()
+	c.writeMutex.Unlock()
+	c.readMutex.Lock()
+	if !c.readClosed && c.readClose != nil {
+		c.readClose()
+	}
+	c.readClosed = true
+	c.readMutex.Unlock()
+	return err
+}
@@ -0,0 +1,31 @@
+package support
+
+import (
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	for _, encoding := range []string{CompressionGzip, CompressionZstd} {
+		t.Run(encoding, func(t *testing.T) {
+			header := http.Header{CompressionHeader: {encoding}}
+			srv := echoServer(t, header, func(conn io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+				return NewCompressedConn(conn, encoding)
+			})
+			defer srv.Close()
+			streamRoundTrip(t, srv, HijackHttpOptions{Compression: []string{encoding}})
+		})
+	}
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://localhost", nil)
+	if encoding := NegotiateCompression(req); encoding != "" {
+		t.Fatal(encoding)
+	}
+	req.Header.Set(AcceptCompressionHeader, "br, zstd, gzip")
+	if encoding := NegotiateCompression(req); encoding != CompressionZstd {
+		t.Fatal(encoding)
+	}
+}
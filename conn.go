@@ -0,0 +1,303 @@
+package support
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// newNetConn returns the given stream as net.Conn.
+// Streams that are not a net.Conn are wrapped in a connection without addresses and deadline support.
+func newNetConn(stream io.ReadWriteCloser) net.Conn {
+	if conn, ok := stream.(net.Conn); ok {
+		return conn
+	}
+	return &streamConn{stream}
+}
+
+// streamConn adapts an io.ReadWriteCloser to the net.Conn interface.
+type streamConn struct {
+	io.ReadWriteCloser
+}
+
+func (c *streamConn) CloseWrite() error {
+	if cw, ok := c.ReadWriteCloser.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func (c *streamConn) LocalAddr() net.Addr                { return streamAddr{} }
+func (c *streamConn) RemoteAddr() net.Addr               { return streamAddr{} }
+func (c *streamConn) SetDeadline(t time.Time) error      { return nil }
+func (c *streamConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *streamConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// streamAddr is the address of a streamConn.
+type streamAddr struct{}
+
+func (streamAddr) Network() string { return "stream" }
+func (streamAddr) String() string  { return "stream" }
+
+// syncWriter serializes writes to an underlying writer.
+type syncWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.w.Write(p)
+}
+
+// callbackWriteTimeout is the time a callback writing to a hijacked connection (e.g. HijackServerOptions.OnIdle)
+// waits for a write of the handler to finish, before its write is skipped.
+const callbackWriteTimeout = 5 * time.Second
+
+var ErrWriteBusy = errors.New("Connection is busy writing")
+
+// writeLock serializes the writes of a handler to a hijacked connection with the writes of callbacks running in
+// other goroutines (timers, the reader of the connection), so frames are never interleaved.
+type writeLock chan struct{}
+
+func newWriteLock() writeLock {
+	return make(writeLock, 1)
+}
+
+// lockedConn is a connection whose writes hold a writeLock.
+type lockedConn struct {
+	net.Conn
+	lock writeLock
+}
+
+func (c *lockedConn) Write(p []byte) (int, error) {
+	c.lock <- struct{}{}
+	defer func() { <-c.lock }()
+	return c.Conn.Write(p)
+}
+
+func (c *lockedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// lockedWriter is the writer of a callback writing to a connection whose writes hold the same writeLock. If a write
+// of the connection does not finish within callbackWriteTimeout (e.g. a stalled client), ErrWriteBusy is returned.
+type lockedWriter struct {
+	w    io.Writer
+	lock writeLock
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	timer := time.NewTimer(callbackWriteTimeout)
+	defer timer.Stop()
+	select {
+	case w.lock <- struct{}{}:
+	case <-timer.C:
+		return 0, ErrWriteBusy
+	}
+	defer func() { <-w.lock }()
+	return w.w.Write(p)
+}
+
+// bufferedConn is a hijacked connection whose reads are served from the buffered reader
+// that was returned by the hijack, so no data read ahead during the HTTP handshake is lost.
+type bufferedConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.br.Read(p)
+}
+
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// deadlineConn sets a deadline before every read and write, so a stalled direction of a hijacked connection times out.
+type deadlineConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	if c.readTimeout > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	if c.writeTimeout > 0 {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *deadlineConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// idleConn closes a connection that has had no traffic in either direction for a timeout.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+// newIdleConn returns the given connection closing it after the given idle timeout.
+// If set, onIdle is called with the connection right before it is closed.
+func newIdleConn(conn net.Conn, timeout time.Duration, onIdle func(w io.Writer)) *idleConn {
+	c := &idleConn{Conn: conn, timeout: timeout}
+	c.timer = time.AfterFunc(timeout, func() {
+		if onIdle != nil {
+			onIdle(conn)
+		}
+		// The timer has fired, c.timer might not even be set yet
+		conn.Close()
+	})
+	return c
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.timer.Reset(c.timeout)
+	}
+	return n, err
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.timer.Reset(c.timeout)
+	}
+	return n, err
+}
+
+func (c *idleConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func (c *idleConn) Close() error {
+	c.timer.Stop()
+	return c.Conn.Close()
+}
+
+// maxDurationConn closes a connection once it has been open for a maximum duration.
+type maxDurationConn struct {
+	net.Conn
+	warning *time.Timer
+	end     *time.Timer
+}
+
+// newMaxDurationConn returns the given connection closing it after the given maximum duration.
+// If set, onWarning is called with the connection the given warning time before it is closed and onEnd right before.
+func newMaxDurationConn(conn net.Conn, max, warning time.Duration, onWarning func(w io.Writer), onEnd func()) *maxDurationConn {
+	c := &maxDurationConn{Conn: conn}
+	if onWarning != nil && warning > 0 && warning < max {
+		c.warning = time.AfterFunc(max-warning, func() {
+			onWarning(conn)
+		})
+	}
+	c.end = time.AfterFunc(max, func() {
+		if onEnd != nil {
+			onEnd()
+		}
+		conn.Close()
+	})
+	return c
+}
+
+func (c *maxDurationConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func (c *maxDurationConn) Close() error {
+	if c.warning != nil {
+		c.warning.Stop()
+	}
+	c.end.Stop()
+	return c.Conn.Close()
+}
+
+// quotaConn limits the total number of bytes read from and written to a connection.
+type quotaConn struct {
+	net.Conn
+	maxIn      int64
+	maxOut     int64
+	in         int64
+	out        int64
+	once       sync.Once
+	onExceeded func(writing bool)
+}
+
+// newQuotaConn returns the given connection limited to the given number of bytes read and written (0 means unlimited).
+// When a quota is exceeded, onExceeded is called and the connection is closed. It is passed whether the quota has been
+// exceeded by a write (so it is called by the writer of the connection) or by a read.
+func newQuotaConn(conn net.Conn, maxIn, maxOut int64, onExceeded func(writing bool)) *quotaConn {
+	return &quotaConn{Conn: conn, maxIn: maxIn, maxOut: maxOut, onExceeded: onExceeded}
+}
+
+func (c *quotaConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if c.maxIn > 0 {
+		if total := atomic.AddInt64(&c.in, int64(n)); total > c.maxIn {
+			c.exceeded(false)
+			n -= int(min(total-c.maxIn, int64(n)))
+			return n, ErrQuotaExceeded
+		}
+	}
+	return n, err
+}
+
+func (c *quotaConn) Write(p []byte) (int, error) {
+	if c.maxOut > 0 && atomic.AddInt64(&c.out, int64(len(p))) > c.maxOut {
+		// Nothing of the write is sent, so frames are never cut
+		c.exceeded(true)
+		return 0, ErrQuotaExceeded
+	}
+	return c.Conn.Write(p)
+}
+
+// exceeded ends the connection once a quota has been exceeded.
+func (c *quotaConn) exceeded(writing bool) {
+	c.once.Do(func() {
+		if c.onExceeded != nil {
+			c.onExceeded(writing)
+		}
+		c.Conn.Close()
+	})
+}
+
+func (c *quotaConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package support
+
+import (
+	"io"
+
+	mobyterm "github.com/moby/term"
+)
+
+// ConsoleStreams returns the standard streams of the local console for use as InputStream, OutputStream
+// and ErrorStream of interactive sessions.
+// On windows consoles without native support for virtual terminal sequences (cmd.exe, older PowerShell hosts),
+// ANSI escape sequences in the output are emulated and input keys are translated into the sequences a remote
+// terminal expects. On other platforms the os standard streams are returned.
+func ConsoleStreams() (stdin io.ReadCloser, stdout, stderr io.Writer) {
+	return mobyterm.StdStreams()
+}
@@ -0,0 +1,71 @@
+//go:build !windows
+
+package support
+
+import (
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// setCredential makes the given command run as the given user and group (names or numeric ids, either may be empty).
+func setCredential(cmd *exec.Cmd, username, groupname string) error {
+	credential := &syscall.Credential{Uid: uint32(syscall.Getuid()), Gid: uint32(syscall.Getgid())}
+	if username != "" {
+		u, err := lookupUser(username)
+		if err != nil {
+			return err
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return err
+		}
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return err
+		}
+		credential.Uid, credential.Gid = uint32(uid), uint32(gid)
+		groups, err := u.GroupIds()
+		if err != nil {
+			return err
+		}
+		for _, group := range groups {
+			if id, err := strconv.ParseUint(group, 10, 32); err == nil {
+				credential.Groups = append(credential.Groups, uint32(id))
+			}
+		}
+	}
+	if groupname != "" {
+		g, err := lookupGroup(groupname)
+		if err != nil {
+			return err
+		}
+		gid, err := strconv.ParseUint(g.Gid, 10, 32)
+		if err != nil {
+			return err
+		}
+		credential.Gid = uint32(gid)
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = credential
+	return nil
+}
+
+// lookupUser looks up the user with the given name or numeric uid.
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return user.LookupId(name)
+	}
+	return user.Lookup(name)
+}
+
+// lookupGroup looks up the group with the given name or numeric gid.
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return user.LookupGroupId(name)
+	}
+	return user.LookupGroup(name)
+}
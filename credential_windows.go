@@ -0,0 +1,13 @@
+package support
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var ErrCredentialUnsupported = errors.New("Running commands as another user is not supported on windows")
+
+// setCredential fails, since windows processes cannot be started with a uid and gid.
+func setCredential(cmd *exec.Cmd, username, groupname string) error {
+	return ErrCredentialUnsupported
+}
@@ -0,0 +1,105 @@
+package support
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	neturl "net/url"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// dialSession connects to the endpoint of the given URL for the given session like dial.
+// If the options of the session use ProxyProtocol, the PROXY protocol header is sent first. For https and wss URLs
+// the header is sent before the TLS handshake, the connection is established over TCP regardless of the transport
+// registered for the scheme. Unless a transport has been registered for the scheme, the TLS handshake of these URLs
+// is traced and timed separately from dialing, as is the DNS lookup of TCP URLs (see Session.Timings).
+func dialSession(s *Session, ep *neturl.URL) (net.Conn, error) {
+	return dialSessionConn(s, ep, false, nil)
+}
+
+// redialSession connects again like dialSession to retry the request (e.g. answering a digest challenge), without
+// passing the connection to OnConnect and the StatsRecorder or overwriting the timings of the first connection.
+func redialSession(s *Session, ep *neturl.URL) (net.Conn, error) {
+	return dialSessionConn(s, ep, true, nil)
+}
+
+// dialSessionH2 connects like dialSession for HTTP/2, negotiating h2 using ALPN in the TLS handshake of https URLs.
+// Connections of other URLs (and of transports registered for https) use HTTP/2 with prior knowledge.
+func dialSessionH2(s *Session, ep *neturl.URL) (net.Conn, error) {
+	return dialSessionConn(s, ep, false, []string{http2.NextProtoTLS})
+}
+
+// dialSessionConn connects like dialSession, for a retry if redial is set. The given protocols are offered using
+// ALPN in the TLS handshake, if any.
+func dialSessionConn(s *Session, ep *neturl.URL, redial bool, nextProtos []string) (conn net.Conn, err error) {
+	options := s.options
+	registered := isRegisteredTransport(ep.Scheme)
+	secure := (ep.Scheme == "https" || ep.Scheme == "wss") && (options.ProxyProtocol != 0 || !registered)
+	span := s.startSpan(SpanDial)
+	started := s.phaseStarted
+	defer func() {
+		if options.Stats != nil && !redial {
+			options.Stats.OnDial(time.Since(started), err)
+		}
+	}()
+	var dns time.Duration
+	if secure || (!registered && ep.Scheme != "unix") {
+		conn, dns, err = dialTCPTimed(ep)
+	} else {
+		conn, err = dial(ep)
+	}
+	if err == nil && options.ProxyProtocol != 0 {
+		if err = WriteProxyHeader(conn, options.ProxyProtocol, conn.LocalAddr(), conn.RemoteAddr()); err != nil {
+			conn.Close()
+		}
+	}
+	connect := time.Since(s.phaseStarted) - dns
+	if !redial {
+		s.recordTiming(func(t *Timings) {
+			t.DNS, t.Connect = dns, connect
+		})
+	}
+	endSpan(span, err)
+	if err != nil {
+		logDialFailure(options, redial, "Dialing %s failed %#v", ep.Redacted(), err)
+		return nil, err
+	}
+	if !secure {
+		if !redial {
+			s.connected()
+		}
+		return newDumpConn(conn, options.TraceWriter), nil
+	}
+
+	span = s.startSpan(SpanTLSHandshake)
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: ep.Hostname(), NextProtos: nextProtos})
+	err = tlsConn.Handshake()
+	handshake := time.Since(s.phaseStarted)
+	if !redial {
+		s.recordTiming(func(t *Timings) {
+			t.TLSHandshake = handshake
+		})
+	}
+	endSpan(span, err)
+	if err != nil {
+		conn.Close()
+		logDialFailure(options, redial, "TLS handshake with %s failed %#v", ep.Redacted(), err)
+		return nil, fmt.Errorf("TLS handshake with %s failed: %w", ep.Host, err)
+	}
+	if !redial {
+		s.connected()
+	}
+	return newDumpConn(tlsConn, options.TraceWriter), nil
+}
+
+// logDialFailure logs a failure to connect to the server as error, or as warning when redialing, as the request
+// that is retried has been answered already.
+func logDialFailure(options HijackHttpOptions, redial bool, msg string, args ...interface{}) {
+	if redial {
+		levels(options.Log).Warnf(msg, args...)
+	} else {
+		levels(options.Log).Errorf(msg, args...)
+	}
+}
@@ -0,0 +1,230 @@
+package support
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+)
+
+// digestAlgorithms are the supported digest auth algorithms (RFC 7616), the one with the highest preference is used.
+var digestAlgorithms = map[string]struct {
+	preference int
+	hash       func() hash.Hash
+}{
+	"SHA-512-256": {3, sha512.New512_256},
+	"SHA-256":     {2, sha256.New},
+	"MD5":         {1, md5.New},
+}
+
+// digestChallenge is a digest auth challenge of a WWW-Authenticate header.
+type digestChallenge map[string]string
+
+// sendRequest sends the given request over the given connection. If the server answers with a digest challenge
+// and the options use DigestAuth, the request is sent again with credentials, over the same connection if the server
+// keeps it open or else over a new connection of redial (if set). The connection of the returned response is returned
+// together with it and has to be closed by the caller.
+func sendRequest(options HijackHttpOptions, conn net.Conn, req *http.Request, redial func() (net.Conn, error)) (*httputil.ClientConn, *http.Response, error) {
+	clientconn := httputil.NewClientConn(conn, nil)
+	dumpRequest(options, req)
+	res, err := clientconn.Do(req)
+	dumpResponse(options, res)
+	storeCookies(options, req, res)
+	if !options.DigestAuth || res == nil || res.StatusCode != http.StatusUnauthorized || (err != nil && err != httputil.ErrPersistEOF) {
+		return clientconn, res, err
+	}
+	challenge := selectDigestChallenge(res.Header.Values("WWW-Authenticate"))
+	if challenge == nil || (err != nil && redial == nil) {
+		return clientconn, res, err
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return clientconn, nil, err
+		}
+		retry.Body = body
+	}
+	authorization, err := challenge.authorization(options.Username, options.Password, req.Method, req.URL.RequestURI())
+	if err != nil {
+		return clientconn, nil, err
+	}
+	retry.Header.Set("Authorization", authorization)
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if res.Close {
+		conn, err := redial()
+		if err != nil {
+			return clientconn, nil, err
+		}
+		clientconn.Close()
+		clientconn = httputil.NewClientConn(conn, nil)
+	}
+	dumpRequest(options, retry)
+	res, err = clientconn.Do(retry)
+	dumpResponse(options, res)
+	storeCookies(options, retry, res)
+	return clientconn, res, err
+}
+
+// selectDigestChallenge returns the digest challenge with the most preferred supported algorithm of the given
+// WWW-Authenticate header values, nil if there is none.
+func selectDigestChallenge(values []string) digestChallenge {
+	var selected digestChallenge
+	for _, value := range values {
+		for _, challenge := range parseDigestChallenges(value) {
+			if qop := challenge["qop"]; qop != "" && !containsToken(qop, "auth") {
+				continue
+			}
+			algorithm, ok := digestAlgorithms[challenge.algorithm()]
+			if !ok {
+				continue
+			}
+			if selected == nil || algorithm.preference > digestAlgorithms[selected.algorithm()].preference {
+				selected = challenge
+			}
+		}
+	}
+	return selected
+}
+
+// parseDigestChallenges parses the digest challenges of a WWW-Authenticate header value, which may contain
+// challenges of other schemes as well.
+func parseDigestChallenges(value string) []digestChallenge {
+	var challenges []digestChallenge
+	var current digestChallenge
+	s := value
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return challenges
+		}
+		end := strings.IndexAny(s, " \t,=")
+		if end < 0 || s[end] != '=' || strings.HasPrefix(s[end:], "==") {
+			// A token without value starts a new challenge (token68 values of other schemes are skipped)
+			if end = strings.IndexAny(s, " \t,"); end < 0 {
+				end = len(s)
+			}
+			current = nil
+			if strings.EqualFold(s[:end], "Digest") {
+				current = make(digestChallenge)
+				challenges = append(challenges, current)
+			}
+			s = s[end:]
+			continue
+		}
+		name := strings.ToLower(s[:end])
+		s = s[end+1:]
+		var param string
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			if i < len(s) {
+				i++
+			}
+			param, s = b.String(), s[i:]
+		} else {
+			end := strings.IndexAny(s, " \t,")
+			if end < 0 {
+				end = len(s)
+			}
+			param, s = s[:end], s[end:]
+		}
+		if current != nil {
+			current[name] = param
+		}
+	}
+}
+
+// algorithm returns the algorithm of the challenge without -sess suffix.
+func (c digestChallenge) algorithm() string {
+	algorithm := strings.ToUpper(c["algorithm"])
+	if algorithm == "" {
+		return "MD5"
+	}
+	return strings.TrimSuffix(algorithm, "-SESS")
+}
+
+// authorization returns the Authorization header answering the challenge for a request with the given method and URI.
+func (c digestChallenge) authorization(username, password, method, uri string) (string, error) {
+	newHash := digestAlgorithms[c.algorithm()].hash
+	h := func(s string) string {
+		hash := newHash()
+		io.WriteString(hash, s)
+		return hex.EncodeToString(hash.Sum(nil))
+	}
+	cnonce := make([]byte, 16)
+	if _, err := rand.Read(cnonce); err != nil {
+		return "", err
+	}
+	cnonceHex := hex.EncodeToString(cnonce)
+	realm, nonce := c["realm"], c["nonce"]
+
+	ha1 := h(username + ":" + realm + ":" + password)
+	if strings.HasSuffix(strings.ToUpper(c["algorithm"]), "-SESS") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonceHex)
+	}
+	ha2 := h(method + ":" + uri)
+	var response string
+	qop := c["qop"] != ""
+	if qop {
+		response = h(ha1 + ":" + nonce + ":00000001:" + cnonceHex + ":auth:" + ha2)
+	} else {
+		response = h(ha1 + ":" + nonce + ":" + ha2)
+	}
+
+	if strings.EqualFold(c["userhash"], "true") {
+		username = h(username + ":" + realm)
+	}
+	params := []string{
+		"username=" + quoteDigest(username),
+		"realm=" + quoteDigest(realm),
+		"nonce=" + quoteDigest(nonce),
+		"uri=" + quoteDigest(uri),
+		"response=" + quoteDigest(response),
+	}
+	if algorithm, ok := c["algorithm"]; ok {
+		params = append(params, "algorithm="+algorithm)
+	}
+	if opaque, ok := c["opaque"]; ok {
+		params = append(params, "opaque="+quoteDigest(opaque))
+	}
+	if qop {
+		params = append(params, "qop=auth", "nc=00000001", "cnonce="+quoteDigest(cnonceHex))
+	}
+	if strings.EqualFold(c["userhash"], "true") {
+		params = append(params, "userhash=true")
+	}
+	return "Digest " + strings.Join(params, ", "), nil
+}
+
+// quoteDigest returns the given value as quoted string.
+func quoteDigest(value string) string {
+	return fmt.Sprintf(`"%s"`, strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value))
+}
+
+// containsToken returns true if the given comma-separated list contains the given token.
+func containsToken(list, token string) bool {
+	for _, t := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -19,6 +19,7 @@ package support
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 )
 
@@ -34,6 +35,9 @@ var (
 	Stdin  StdType = StdType{0: 0}
 	Stdout StdType = StdType{0: 1}
 	Stderr StdType = StdType{0: 2}
+	// Exit frames carry the exit status of the remote process at the end of the stream.
+	// They are not part of the docker format and only sent to clients that requested them.
+	Exit StdType = StdType{0: 4}
 )
 
 type Logger interface {
@@ -81,6 +85,19 @@ func NewStdWriter(w io.Writer, t StdType) *StdWriter {
 
 var ErrInvalidStdHeader = errors.New("Unrecognized input header")
 
+// DefaultMaxFrameSize is the maximum size of a frame accepted by StdCopy.
+const DefaultMaxFrameSize = 16 * 1024 * 1024
+
+// FrameSizeError is returned when the length prefix of a frame exceeds the maximum frame size.
+type FrameSizeError struct {
+	Size    int
+	MaxSize int
+}
+
+func (e *FrameSizeError) Error() string {
+	return fmt.Sprintf("Frame size %d exceeds maximum of %d", e.Size, e.MaxSize)
+}
+
 // StdCopy is a modified version of io.Copy.
 //
 // StdCopy will demultiplex `src`, assuming that it contains two streams,
@@ -92,6 +109,32 @@ var ErrInvalidStdHeader = errors.New("Unrecognized input header")
 //
 // `written` will hold the total number of bytes written to `dstout` and `dsterr`.
 func StdCopy(dstout, dsterr io.Writer, src io.Reader, logrus Logger) (written int64, err error) {
+	return StdCopyWithExit(dstout, dsterr, nil, src, logrus)
+}
+
+// StdCopyWithExit is StdCopy that additionally writes the payload of Exit frames to `dstexit`.
+// If `dstexit` is nil, Exit frames are treated as invalid headers.
+func StdCopyWithExit(dstout, dsterr, dstexit io.Writer, src io.Reader, logrus Logger) (written int64, err error) {
+	dst := map[uint8]io.Writer{
+		0: dstout,
+		1: dstout,
+		2: dsterr,
+	}
+	if dstexit != nil {
+		dst[4] = dstexit
+	}
+	return StdCopyStreams(dst, src, logrus)
+}
+
+// StdCopyStreams is StdCopy that writes the frames of every stream to the writer of its fd number in `dst`.
+// Frames of fd numbers that are not in `dst` are treated as invalid headers.
+func StdCopyStreams(dst map[uint8]io.Writer, src io.Reader, logrus Logger) (written int64, err error) {
+	return StdCopyStreamsLimit(dst, src, DefaultMaxFrameSize, logrus)
+}
+
+// StdCopyStreamsLimit is StdCopyStreams that rejects frames larger than `maxFrameSize` with a *FrameSizeError,
+// instead of allocating a buffer for them.
+func StdCopyStreamsLimit(dst map[uint8]io.Writer, src io.Reader, maxFrameSize int, logrus Logger) (written int64, err error) {
 	var (
 		buf       = make([]byte, 32*1024+StdWriterPrefixLen+1)
 		bufLen    = len(buf)
@@ -121,16 +164,8 @@ func StdCopy(dstout, dsterr io.Writer, src io.Reader, logrus Logger) (written in
 		}
 
 		// Check the first byte to know where to write
-		switch buf[StdWriterFdIndex] {
-		case 0:
-			fallthrough
-		case 1:
-			// Write on stdout
-			out = dstout
-		case 2:
-			// Write on stderr
-			out = dsterr
-		default:
+		var ok bool
+		if out, ok = dst[buf[StdWriterFdIndex]]; !ok || out == nil {
 			logrus.Debugf("Error selecting output fd: (%d)", buf[StdWriterFdIndex])
 			return 0, ErrInvalidStdHeader
 		}
@@ -138,6 +173,10 @@ func StdCopy(dstout, dsterr io.Writer, src io.Reader, logrus Logger) (written in
 		// Retrieve the size of the frame
 		frameSize = int(binary.BigEndian.Uint32(buf[StdWriterSizeIndex : StdWriterSizeIndex+4]))
 		logrus.Debugf("framesize: %d", frameSize)
+		if frameSize < 0 || frameSize > maxFrameSize {
+			logrus.Debugf("Error frame too large: (%d > %d)", frameSize, maxFrameSize)
+			return 0, &FrameSizeError{Size: frameSize, MaxSize: maxFrameSize}
+		}
 
 		// Check if the buffer is big enough to read the frame.
 		// Extend it if necessary.
@@ -0,0 +1,114 @@
+package support
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+)
+
+// redactedHeaders are the headers whose values are redacted in handshake dumps.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Amz-Security-Token"}
+
+// redactedResponseHeaders are the response headers whose values are redacted in handshake dumps.
+var redactedResponseHeaders = []string{"Set-Cookie"}
+
+// dumpConn writes a hex/ASCII dump of all data read from and written to a connection (see
+// HijackHttpOptions.TraceWriter).
+type dumpConn struct {
+	net.Conn
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// newDumpConn returns the given connection dumping its data to the given writer, or the connection itself if the
+// writer is nil.
+func newDumpConn(conn net.Conn, w io.Writer) net.Conn {
+	if w == nil {
+		return conn
+	}
+	return &dumpConn{Conn: conn, w: w}
+}
+
+func (c *dumpConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.dump("read", p[:n])
+	return n, err
+}
+
+func (c *dumpConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.dump("written", p[:n])
+	return n, err
+}
+
+func (c *dumpConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// dump writes a dump of the given data transferred in the given direction.
+func (c *dumpConn) dump(direction string, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	fmt.Fprintf(c.w, "%s %d bytes %s\n%s", time.Now().Format(time.RFC3339Nano), len(p), direction, hex.Dump(p))
+}
+
+// dumpRequest dumps the given handshake request without body if the options have DumpHandshake set.
+func dumpRequest(options HijackHttpOptions, req *http.Request) {
+	if !options.DumpHandshake {
+		return
+	}
+	dump := *req
+	dump.Header = redactHeaders(req.Header, redactedHeaders)
+	b, err := httputil.DumpRequest(&dump, false)
+	if err != nil {
+		levels(options.Log).Warnf("Dumping handshake request failed %#v", err)
+		return
+	}
+	writeDump(options, "Handshake request", b)
+}
+
+// dumpResponse dumps the head of the given handshake response if the options have DumpHandshake set.
+func dumpResponse(options HijackHttpOptions, res *http.Response) {
+	if !options.DumpHandshake || res == nil {
+		return
+	}
+	dump := *res
+	dump.Header = redactHeaders(res.Header, redactedResponseHeaders)
+	b, err := httputil.DumpResponse(&dump, false)
+	if err != nil {
+		levels(options.Log).Warnf("Dumping handshake response failed %#v", err)
+		return
+	}
+	writeDump(options, "Handshake response", b)
+}
+
+// redactHeaders returns a copy of the given header with the values of the given headers redacted.
+func redactHeaders(header http.Header, names []string) http.Header {
+	header = header.Clone()
+	for _, name := range names {
+		if header.Get(name) != "" {
+			header.Set(name, "REDACTED")
+		}
+	}
+	return header
+}
+
+// writeDump writes the given dump to the HandshakeWriter of the options or else to their logger.
+func writeDump(options HijackHttpOptions, title string, b []byte) {
+	if options.HandshakeWriter != nil {
+		fmt.Fprintf(options.HandshakeWriter, "%s:\n%s", title, b)
+	} else {
+		options.Log.Debugf("%s:\n%s", title, b)
+	}
+}
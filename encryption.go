@@ -0,0 +1,224 @@
+package support
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	encryptionNonceLen   = 24
+	encryptionMaxMessage = 32 * 1024
+)
+
+// Types of encrypted messages, the first byte of their plaintext.
+const (
+	encryptionData  byte = 0
+	encryptionClose byte = 1 // Ends the stream of the sender, so a truncated stream is detected
+)
+
+var ErrDecryptionFailed = errors.New("Decryption of stream message failed")
+
+// encryptedConn encrypts every write into a length-prefixed NaCl secretbox message and decrypts read messages.
+// Each direction uses its own key and the nonces are message counters, so messages can neither be replayed,
+// reordered nor reflected to their sender.
+type encryptedConn struct {
+	conn    io.ReadWriteCloser
+	sendKey [32]byte
+	recvKey [32]byte
+
+	writeMutex  sync.Mutex
+	sendCounter uint64
+	writeClosed bool
+
+	recvCounter uint64
+	pending     []byte // Decrypted data not yet returned by Read
+	readClosed  bool   // Set once the close message of the peer has been read
+}
+
+// NewEncryptedConn wraps the given hijacked connection, encrypting written data using NaCl secretbox with keys derived
+// from the given key and decrypting read data. Both sides must use the same key, e.g. a pre-shared key or one returned
+// by HandshakeEncryptionKey, and client must be set on the side that sent the request only.
+// The stream of each side ends with an authenticated close message sent by CloseWrite or Close, a stream ending
+// without it returns ErrStreamTruncated.
+func NewEncryptedConn(conn io.ReadWriteCloser, key *[32]byte, client bool) io.ReadWriteCloser {
+	c := &encryptedConn{conn: conn}
+	clientKey, serverKey := encryptionDirectionKey(key, "client to server"), encryptionDirectionKey(key, "server to client")
+	if client {
+		c.sendKey, c.recvKey = clientKey, serverKey
+	} else {
+		c.sendKey, c.recvKey = serverKey, clientKey
+	}
+	return c
+}
+
+// encryptionDirectionKey derives the key of the direction with the given label from the given key.
+func encryptionDirectionKey(key *[32]byte, direction string) [32]byte {
+	var derived [32]byte
+	// Cannot fail for a key of this length
+	k, _ := hkdf.Key(sha256.New, key[:], nil, "hijack-stream-support "+direction, len(derived))
+	copy(derived[:], k)
+	return derived
+}
+
+// encryptionNonce returns the nonce of the message with the given counter.
+func encryptionNonce(counter uint64) *[encryptionNonceLen]byte {
+	var nonce [encryptionNonceLen]byte
+	binary.BigEndian.PutUint64(nonce[encryptionNonceLen-8:], counter)
+	return &nonce
+}
+
+func (c *encryptedConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if c.readClosed {
+			return 0, io.EOF
+		}
+		var prefix [4]byte
+		if _, err := io.ReadFull(c.conn, prefix[:]); err != nil {
+			if err == io.EOF {
+				return 0, ErrStreamTruncated
+			}
+			return 0, err
+		}
+		size := int(binary.BigEndian.Uint32(prefix[:]))
+		if size < secretbox.Overhead+1 || size > secretbox.Overhead+1+encryptionMaxMessage {
+			return 0, ErrDecryptionFailed
+		}
+		message := make([]byte, size)
+		if _, err := io.ReadFull(c.conn, message); err != nil {
+			if err == io.EOF {
+				err = ErrStreamTruncated
+			}
+			return 0, err
+		}
+		// Only the next message of the peer can be opened, any other fails authentication
+		plain, ok := secretbox.Open(nil, message, encryptionNonce(c.recvCounter), &c.recvKey)
+		if !ok {
+			return 0, ErrDecryptionFailed
+		}
+		c.recvCounter++
+		switch plain[0] {
+		case encryptionData:
+			c.pending = plain[1:]
+		case encryptionClose:
+			c.readClosed = true
+		default:
+			return 0, ErrDecryptionFailed
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *encryptedConn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if c.writeClosed {
+		return 0, io.ErrClosedPipe
+	}
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > encryptionMaxMessage {
+			n = encryptionMaxMessage
+		}
+		if err := c.writeMessage(encryptionData, p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// writeMessage encrypts and writes a message of the given type, the write mutex must be held.
+func (c *encryptedConn) writeMessage(kind byte, data []byte) error {
+	plain := make([]byte, 0, 1+len(data))
+	plain = append(plain, kind)
+	plain = append(plain, data...)
+	message := make([]byte, 4, 4+secretbox.Overhead+len(plain))
+	message = secretbox.Seal(message, plain, encryptionNonce(c.sendCounter), &c.sendKey)
+	c.sendCounter++
+	binary.BigEndian.PutUint32(message, uint32(len(message)-4))
+	_, err := c.conn.Write(message)
+	return err
+}
+
+// closeMessage writes the close message once, the write mutex must be held.
+func (c *encryptedConn) closeMessage() error {
+	if c.writeClosed {
+		return nil
+	}
+	c.writeClosed = true
+	return c.writeMessage(encryptionClose, nil)
+}
+
+func (c *encryptedConn) CloseWrite() error {
+	c.writeMutex.Lock()
+	err := c.closeMessage()
+	c.writeMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if cw, ok := c.conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func (c *encryptedConn) Close() error {
+	// A pending write (e.g. to a stalled peer) must not block closing, the stream is truncated then
+	if c.writeMutex.TryLock() {
+		c.closeMessage()
+		c.writeMutex.Unlock()
+	}
+	return c.conn.Close()
+}
+
+// HandshakeEncryptionKey performs an X25519 key exchange over the given hijacked connection and returns the derived
+// key for NewEncryptedConn. If psk is set, it is mixed into the derived key, so only peers knowing it can communicate.
+// Without a pre-shared key the exchange is unauthenticated and only protects against passive observers.
+// Both sides must call it right after the connection has been hijacked.
+func HandshakeEncryptionKey(conn io.ReadWriter, psk *[32]byte) (*[32]byte, error) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	// Send our public key concurrently, since both sides write before reading
+	errs := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(private.PublicKey().Bytes())
+		errs <- err
+	}()
+	peerKey := make([]byte, 32)
+	if _, err := io.ReadFull(conn, peerKey); err != nil {
+		return nil, err
+	}
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	peer, err := ecdh.X25519().NewPublicKey(peerKey)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := private.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	hash.Write(shared)
+	if psk != nil {
+		hash.Write(psk[:])
+	}
+	var key [32]byte
+	copy(key[:], hash.Sum(nil))
+	return &key, nil
+}
@@ -0,0 +1,28 @@
+package support
+
+import (
+	"io"
+	"testing"
+)
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	key := &[32]byte{1, 2, 3}
+	srv := echoServer(t, nil, func(conn io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+		return NewEncryptedConn(conn, key, false), nil
+	})
+	defer srv.Close()
+	streamRoundTrip(t, srv, HijackHttpOptions{EncryptionKey: key})
+}
+
+func TestEncryptionHandshakeRoundTrip(t *testing.T) {
+	psk := &[32]byte{4, 5, 6}
+	srv := echoServer(t, nil, func(conn io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+		key, err := HandshakeEncryptionKey(conn, psk)
+		if err != nil {
+			return nil, err
+		}
+		return NewEncryptedConn(conn, key, false), nil
+	})
+	defer srv.Close()
+	streamRoundTrip(t, srv, HijackHttpOptions{EncryptionKey: psk, EncryptionHandshake: true})
+}
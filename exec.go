@@ -0,0 +1,111 @@
+package support
+
+import (
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+
+	docker "github.com/giantswarm/hijack-stream-support/docker"
+)
+
+type ExecOptions struct {
+	Cmd          []string
+	Env          []string
+	User         string
+	WorkingDir   string
+	Tty          bool      // If set, a TTY is allocated for the process, in which case output is not multiplexed.
+	InputStream  io.Reader // If set, stdin of the process is attached.
+	OutputStream io.Writer // If set, stdout of the process is attached.
+	ErrorStream  io.Writer // If set, stderr of the process is attached.
+	APIVersion   string    // If set, the docker API of this version (e.g. "1.41") is used. Use "auto" to negotiate the version with the daemon.
+	Header       http.Header
+	Log          docker.Logger
+	ErrorHandler func(res *http.Response, err error) error
+}
+
+type execConfig struct {
+	AttachStdin  bool
+	AttachStdout bool
+	AttachStderr bool
+	Tty          bool
+	Cmd          []string
+	Env          []string `json:",omitempty"`
+	User         string   `json:",omitempty"`
+	WorkingDir   string   `json:",omitempty"`
+}
+
+type execStartConfig struct {
+	Detach bool
+	Tty    bool
+}
+
+type execInspect struct {
+	ID       string
+	Running  bool
+	ExitCode int
+}
+
+// Exec runs a command in the container with the given ID using the docker API at the given endpoint.
+// It creates the exec instance, starts it streaming data from/to the streams in the given options,
+// and returns the exit code of the process once the stream has ended.
+func Exec(endpoint, containerID string, options ExecOptions) (int, error) {
+	if containerID == "" {
+		return 0, ErrMissingContainerID
+	}
+	client, err := newAPIClient(endpoint, options.Header)
+	if err != nil {
+		return 0, err
+	}
+	if client.version, err = resolveAPIVersion(endpoint, options.APIVersion, options.Header); err != nil {
+		return 0, err
+	}
+
+	// Create exec instance
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err = client.do("POST", "/containers/"+neturl.PathEscape(containerID)+"/exec", execConfig{
+		AttachStdin:  options.InputStream != nil,
+		AttachStdout: options.OutputStream != nil,
+		AttachStderr: options.ErrorStream != nil,
+		Tty:          options.Tty,
+		Cmd:          options.Cmd,
+		Env:          options.Env,
+		User:         options.User,
+		WorkingDir:   options.WorkingDir,
+	}, &created)
+	if err != nil {
+		return 0, err
+	}
+	execPath := "/exec/" + neturl.PathEscape(created.ID)
+	header := http.Header{}
+	for k, values := range options.Header {
+		header[k] = values
+	}
+	header.Set("Content-Type", "application/json")
+
+	// Start it with a hijacked stream
+	err = HijackHttpRequest(HijackHttpOptions{
+		Method:             "POST",
+		Url:                strings.TrimRight(endpoint, "/") + versionedPath(execPath+"/start", client.version),
+		DockerTermProtocol: !options.Tty,
+		InputStream:        options.InputStream,
+		OutputStream:       options.OutputStream,
+		ErrorStream:        options.ErrorStream,
+		Data:               execStartConfig{Tty: options.Tty},
+		Header:             header,
+		Log:                options.Log,
+		ErrorHandler:       options.ErrorHandler,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Fetch the exit code
+	var inspect execInspect
+	if err := client.do("GET", execPath+"/json", nil, &inspect); err != nil {
+		return 0, err
+	}
+	return inspect.ExitCode, nil
+}
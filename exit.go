@@ -0,0 +1,99 @@
+package support
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+
+	docker "github.com/giantswarm/hijack-stream-support/docker"
+)
+
+// ExitCodeHeader is the request header by which clients ask for an exit frame at the end of the stream.
+const ExitCodeHeader = "X-Hijack-Exit-Code"
+
+// ExitStatus is the payload of the exit frame sent at the end of a multiplexed stream.
+type ExitStatus struct {
+	ExitCode    int
+	Signal      string `json:",omitempty"` // Name of the signal that terminated the process, if any
+	CloseReason string `json:",omitempty"` // Reason the server ended the stream before the process exited, if any (e.g. CloseReasonQuotaExceeded)
+}
+
+// ExitError is returned when the remote process exited with a non-zero exit code or was terminated by a signal.
+type ExitError struct {
+	Code   int
+	Signal string // Name of the signal that terminated the process, if any
+}
+
+func (e *ExitError) Error() string {
+	if e.Signal != "" {
+		return fmt.Sprintf("Process terminated by signal %s", e.Signal)
+	}
+	return fmt.Sprintf("Process exited with code %d", e.Code)
+}
+
+// ClosedError is returned when the server ended the stream before the remote process exited, reporting the reason
+// in the exit frame (see WriteCloseReason).
+type ClosedError struct {
+	Reason string
+}
+
+func (e *ClosedError) Error() string {
+	return fmt.Sprintf("Stream closed by server: %s", e.Reason)
+}
+
+// StderrError is returned in StderrAsError mode when output has been received on the error stream.
+type StderrError struct {
+	Output string
+}
+
+func (e *StderrError) Error() string {
+	return strings.TrimSpace(e.Output)
+}
+
+// ExitCodeRequested returns true if the client of the given request asked for an exit frame.
+func ExitCodeRequested(req *http.Request) bool {
+	return req.Header.Get(ExitCodeHeader) != ""
+}
+
+// WriteExitCode writes the exit frame with the given exit code onto the given hijacked (multiplexed) stream.
+// It must be the last frame written and only be sent if ExitCodeRequested returns true.
+func WriteExitCode(w io.Writer, code int) error {
+	return writeExitStatus(w, ExitStatus{ExitCode: code})
+}
+
+// WriteProcessExit writes the exit frame for the given state of an exited process (see exec.Cmd.ProcessState),
+// reporting the signal if the process was terminated by one. The exit code of such a process is 128 plus the
+// signal number, like in shells. It must be the last frame written and only be sent if ExitCodeRequested returns true.
+func WriteProcessExit(w io.Writer, state *os.ProcessState) error {
+	return writeExitStatus(w, processExitStatus(state))
+}
+
+// WriteCloseReason writes an exit frame reporting that the stream is ended by the server for the given reason
+// onto the given hijacked (multiplexed) stream, returned as *ClosedError by the client. It must be the last frame
+// written and only be sent if ExitCodeRequested returns true.
+func WriteCloseReason(w io.Writer, reason string) error {
+	return writeExitStatus(w, ExitStatus{CloseReason: reason})
+}
+
+// processExitStatus returns the exit status of the given state of an exited process.
+func processExitStatus(state *os.ProcessState) ExitStatus {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return ExitStatus{ExitCode: 128 + int(ws.Signal()), Signal: ws.Signal().String()}
+	}
+	return ExitStatus{ExitCode: state.ExitCode()}
+}
+
+// writeExitStatus writes an exit frame with the given status.
+func writeExitStatus(w io.Writer, status ExitStatus) error {
+	payload, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	// Written as a single frame, so it is never interleaved with the writes of the handler
+	_, err = NewStdWriter(w, StdStream(docker.Exit[0])).Write(payload)
+	return err
+}
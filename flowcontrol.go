@@ -0,0 +1,187 @@
+package support
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Frame types of the flow control layer.
+const (
+	flowData   byte = 0
+	flowCredit byte = 1
+	flowEOF    byte = 2
+)
+
+const (
+	flowHeaderLen    = 5
+	flowMaxFrameSize = 32 * 1024
+)
+
+var ErrFlowControlClosed = errors.New("Flow controlled connection closed")
+
+// FlowControlConn is a credit-based flow control layer over a hijacked connection.
+// Each side may only send as much data as the other side has granted credit for, and credit is only granted
+// when the application has read the received data. A slow reader thereby applies backpressure to the sender
+// instead of relying on TCP buffers. Both sides must use a FlowControlConn with the same window.
+type FlowControlConn struct {
+	rw     io.ReadWriteCloser
+	window int
+
+	writeMutex sync.Mutex // Serializes frames written to rw
+
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	credit   int          // Bytes we may still send
+	received bytes.Buffer // Received data not yet read by the application
+	consumed int          // Bytes read by the application not yet granted as credit
+	eof      bool
+	err      error
+}
+
+// NewFlowControlConn creates a flow control layer with the given window (in bytes) over the given connection.
+func NewFlowControlConn(rw io.ReadWriteCloser, window int) *FlowControlConn {
+	c := &FlowControlConn{
+		rw:     rw,
+		window: window,
+		credit: window,
+	}
+	c.cond = sync.NewCond(&c.mutex)
+	go c.readFrames()
+	return c
+}
+
+// readFrames reads frames from the underlying connection until it fails.
+func (c *FlowControlConn) readFrames() {
+	header := make([]byte, flowHeaderLen)
+	for {
+		if _, err := io.ReadFull(c.rw, header); err != nil {
+			c.fail(err)
+			return
+		}
+		value := int(binary.BigEndian.Uint32(header[1:]))
+		switch header[0] {
+		case flowData:
+			if value > flowMaxFrameSize {
+				c.fail(ErrMessageTooLarge)
+				return
+			}
+			data := make([]byte, value)
+			if _, err := io.ReadFull(c.rw, data); err != nil {
+				c.fail(err)
+				return
+			}
+			c.mutex.Lock()
+			c.received.Write(data)
+			c.mutex.Unlock()
+		case flowCredit:
+			c.mutex.Lock()
+			c.credit += value
+			c.mutex.Unlock()
+		case flowEOF:
+			c.mutex.Lock()
+			c.eof = true
+			c.mutex.Unlock()
+		}
+		c.cond.Broadcast()
+	}
+}
+
+// fail stops the connection with the given error.
+func (c *FlowControlConn) fail(err error) {
+	c.mutex.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mutex.Unlock()
+	c.cond.Broadcast()
+}
+
+// writeFrame writes a single frame to the underlying connection.
+func (c *FlowControlConn) writeFrame(frameType byte, value int, data []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	frame := make([]byte, flowHeaderLen+len(data))
+	frame[0] = frameType
+	binary.BigEndian.PutUint32(frame[1:], uint32(value))
+	copy(frame[flowHeaderLen:], data)
+	_, err := c.rw.Write(frame)
+	return err
+}
+
+// Read reads received data, granting credit to the sender once half of the window has been read.
+func (c *FlowControlConn) Read(p []byte) (int, error) {
+	c.mutex.Lock()
+	for c.received.Len() == 0 && !c.eof && c.err == nil {
+		c.cond.Wait()
+	}
+	if c.received.Len() == 0 {
+		defer c.mutex.Unlock()
+		if c.eof || c.err == io.EOF {
+			return 0, io.EOF
+		}
+		return 0, c.err
+	}
+	n, _ := c.received.Read(p)
+	c.consumed += n
+	grant := 0
+	if c.consumed >= c.window/2 {
+		grant, c.consumed = c.consumed, 0
+	}
+	c.mutex.Unlock()
+
+	if grant > 0 {
+		if err := c.writeFrame(flowCredit, grant, nil); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Write sends the given data, blocking while the receiver has not granted enough credit.
+func (c *FlowControlConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		c.mutex.Lock()
+		for c.credit == 0 && c.err == nil {
+			c.cond.Wait()
+		}
+		if c.err != nil {
+			err := c.err
+			c.mutex.Unlock()
+			if err == io.EOF {
+				err = ErrFlowControlClosed
+			}
+			return written, err
+		}
+		n := len(p)
+		if n > c.credit {
+			n = c.credit
+		}
+		if n > flowMaxFrameSize {
+			n = flowMaxFrameSize
+		}
+		c.credit -= n
+		c.mutex.Unlock()
+
+		if err := c.writeFrame(flowData, n, p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// CloseWrite signals the end of the data to the other side.
+func (c *FlowControlConn) CloseWrite() error {
+	return c.writeFrame(flowEOF, 0, nil)
+}
+
+// Close closes the underlying connection.
+func (c *FlowControlConn) Close() error {
+	c.fail(ErrFlowControlClosed)
+	return c.rw.Close()
+}
@@ -0,0 +1,14 @@
+package support
+
+import (
+	"io"
+	"testing"
+)
+
+func TestFlowControlRoundTrip(t *testing.T) {
+	srv := echoServer(t, nil, func(conn io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+		return NewFlowControlConn(conn, 4096), nil
+	})
+	defer srv.Close()
+	streamRoundTrip(t, srv, HijackHttpOptions{FlowControlWindow: 4096})
+}
@@ -0,0 +1,83 @@
+package support
+
+import (
+	"io"
+	"net"
+)
+
+// ForwardLocalPort listens on the given local TCP address and forwards every accepted connection
+// to the remote side, see ForwardPort.
+func ForwardLocalPort(address string, options HijackHttpOptions) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	return ForwardPort(listener, options)
+}
+
+// ForwardPort accepts connections on the given listener and pipes each of them through a hijacked session
+// described by the given options (the streams of the options are ignored).
+// With the Multiplex option, a single session is used and every connection gets its own logical stream,
+// otherwise a new session is established per connection. ForwardPort returns when the listener is closed.
+func ForwardPort(listener net.Listener, options HijackHttpOptions) error {
+	options, err := prepareOptions(options)
+	if err != nil {
+		return err
+	}
+
+	var s *Session
+	if options.Multiplex {
+		if s, err = StartHijackHttpRequest(options); err != nil {
+			return err
+		}
+		defer s.Close()
+		go func() {
+			// Stop accepting once the session is gone
+			<-s.Done()
+			listener.Close()
+		}()
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if s != nil {
+				stream, err := s.OpenStream()
+				if err != nil {
+					levels(options.Log).Errorf("Opening stream failed %#v", err)
+					return
+				}
+				defer stream.Close()
+				splice(conn, stream)
+				return
+			}
+			connOptions := options
+			connOptions.InputStream = conn
+			connOptions.OutputStream = conn
+			if err := HijackHttpRequest(connOptions); err != nil {
+				levels(options.Log).Warnf("Forwarding connection failed %#v", err)
+			}
+		}()
+	}
+}
+
+// splice copies data between the given connections in both directions until both directions have ended.
+func splice(a, b io.ReadWriter) {
+	done := make(chan struct{}, 2)
+	copyHalf := func(dst, src io.ReadWriter) {
+		io.Copy(dst, src)
+		if cw, ok := dst.(closeWriter); ok {
+			cw.CloseWrite()
+		}
+		done <- struct{}{}
+	}
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	<-done
+	<-done
+}
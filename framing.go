@@ -0,0 +1,93 @@
+package support
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// DefaultMaxMessageSize is the maximum size of a message accepted by a MessageReader.
+const DefaultMaxMessageSize = 16 * 1024 * 1024
+
+var ErrMessageTooLarge = errors.New("Message too large")
+
+// MessageWriter writes messages prefixed with their uvarint encoded length, so message boundaries
+// are preserved over a hijacked byte stream. It is safe for concurrent use.
+type MessageWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+	buf   []byte
+}
+
+// NewMessageWriter creates a writer of length-prefixed messages to w
+// (e.g. the hijacked output stream on the server or a pipe used as InputStream on the client).
+func NewMessageWriter(w io.Writer) *MessageWriter {
+	return &MessageWriter{w: w}
+}
+
+// WriteMessage writes the given message.
+func (w *MessageWriter) WriteMessage(msg []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.buf = append(w.buf[:0], make([]byte, binary.MaxVarintLen64)...)
+	n := binary.PutUvarint(w.buf, uint64(len(msg)))
+	w.buf = append(w.buf[:n], msg...)
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// MessageReader reads messages written by a MessageWriter.
+// It never reads beyond the end of a message, so the underlying reader can be used for other data afterwards.
+type MessageReader struct {
+	r       io.Reader
+	br      io.ByteReader
+	maxSize int
+}
+
+// NewMessageReader creates a reader of length-prefixed messages from r
+// (e.g. the hijacked input stream on the server or a pipe used as OutputStream on the client).
+// Messages larger than maxSize are rejected with ErrMessageTooLarge, 0 means DefaultMaxMessageSize.
+func NewMessageReader(r io.Reader, maxSize int) *MessageReader {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = &byteReader{r: r}
+	}
+	return &MessageReader{r: r, br: br, maxSize: maxSize}
+}
+
+// ReadMessage reads the next message. It returns io.EOF when the stream ends between messages
+// and io.ErrUnexpectedEOF when it ends within a message.
+func (r *MessageReader) ReadMessage() ([]byte, error) {
+	size, err := binary.ReadUvarint(r.br)
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(r.maxSize) {
+		return nil, ErrMessageTooLarge
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r.r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+// byteReader reads single bytes from a reader without buffering.
+type byteReader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(r.r, r.buf[:]); err != nil {
+		return 0, err
+	}
+	return r.buf[0], nil
+}
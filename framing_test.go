@@ -0,0 +1,39 @@
+package support
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewMessageWriter(&buf)
+	messages := [][]byte{[]byte("first"), nil, bytes.Repeat([]byte("x"), 1000)}
+	for _, msg := range messages {
+		if err := w.WriteMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewMessageReader(&buf, 0)
+	for _, want := range messages {
+		msg, err := r.ReadMessage()
+		if err != nil || !bytes.Equal(msg, want) {
+			t.Fatalf("%q %v", msg, err)
+		}
+	}
+	if _, err := r.ReadMessage(); err != io.EOF {
+		t.Fatal(err)
+	}
+}
+
+func TestMessageLimits(t *testing.T) {
+	var buf bytes.Buffer
+	NewMessageWriter(&buf).WriteMessage([]byte("too large"))
+	if _, err := NewMessageReader(bytes.NewReader(buf.Bytes()), 4).ReadMessage(); err != ErrMessageTooLarge {
+		t.Fatal(err)
+	}
+	if _, err := NewMessageReader(bytes.NewReader(buf.Bytes()[:4]), 0).ReadMessage(); err != io.ErrUnexpectedEOF {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,39 @@
+package grpcstream
+
+import (
+	"context"
+	"net"
+
+	support "github.com/giantswarm/hijack-stream-support"
+)
+
+// Dialer returns a dial function for grpc.WithContextDialer that connects using a hijacked connection
+// described by the given options, so gRPC services that are only reachable behind the hijack endpoint
+// can be called transparently. The address passed by gRPC is ignored:
+//
+//	conn, err := grpc.Dial("passthrough:///hijacked", grpc.WithContextDialer(grpcstream.Dialer(hijackOpts)), ...)
+func Dialer(options support.HijackHttpOptions) func(ctx context.Context, addr string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		type result struct {
+			conn net.Conn
+			err  error
+		}
+		results := make(chan result, 1)
+		go func() {
+			conn, err := support.DialHijack(options)
+			results <- result{conn, err}
+		}()
+		select {
+		case r := <-results:
+			return r.conn, r.err
+		case <-ctx.Done():
+			go func() {
+				// Close the connection once the abandoned dial has finished
+				if r := <-results; r.conn != nil {
+					r.conn.Close()
+				}
+			}()
+			return nil, ctx.Err()
+		}
+	}
+}
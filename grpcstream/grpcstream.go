@@ -0,0 +1,172 @@
+// Package grpcstream transfers hijacked stream semantics (stdin, stdout and stderr) over a gRPC
+// bidirectional stream, so gRPC services can reuse the streaming model of this library.
+//
+// The service is defined without generated code. Every message is a google.protobuf.BytesValue
+// whose first byte identifies the stream (see Stdin, Stdout and Stderr) followed by the payload:
+//
+//	service Stream {
+//	  rpc Stream(stream google.protobuf.BytesValue) returns (stream google.protobuf.BytesValue);
+//	}
+package grpcstream
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	support "github.com/giantswarm/hijack-stream-support"
+)
+
+// Stream identifiers, matching the docker multiplexing format.
+const (
+	Stdin  byte = 0
+	Stdout byte = 1
+	Stderr byte = 2
+)
+
+const (
+	ServiceName = "hijack.Stream"
+	methodName  = "Stream"
+	fullMethod  = "/" + ServiceName + "/" + methodName
+)
+
+// StreamServer is the server API of the stream service.
+type StreamServer interface {
+	Stream(ctx context.Context, in io.Reader, out, errOut io.Writer) error
+}
+
+// HandlerFunc is a StreamServer implemented as function.
+type HandlerFunc func(ctx context.Context, in io.Reader, out, errOut io.Writer) error
+
+func (f HandlerFunc) Stream(ctx context.Context, in io.Reader, out, errOut io.Writer) error {
+	return f(ctx, in, out, errOut)
+}
+
+// ServiceDesc is the gRPC service description of the stream service.
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*StreamServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    methodName,
+			Handler:       streamHandler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+}
+
+// RegisterServer registers the given handler as stream service on the given gRPC server.
+func RegisterServer(s grpc.ServiceRegistrar, srv StreamServer) {
+	s.RegisterService(&ServiceDesc, srv)
+}
+
+// Stream calls the stream service over the given client connection and streams data
+// from/to the input, output and error streams of the given options.
+func Stream(ctx context.Context, cc grpc.ClientConnInterface, options support.HijackHttpOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := cc.NewStream(ctx, &ServiceDesc.Streams[0], fullMethod)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if options.InputStream != nil {
+			if _, err := io.Copy(&frameWriter{stream: stream, id: Stdin}, options.InputStream); err != nil {
+				cancel()
+				return
+			}
+		}
+		stream.CloseSend()
+	}()
+
+	stdout := options.OutputStream
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	stderr := options.ErrorStream
+	if stderr == nil {
+		if options.CombineOutput {
+			stderr = stdout
+		} else {
+			stderr = ioutil.Discard
+		}
+	}
+	for {
+		frame := new(wrapperspb.BytesValue)
+		if err := stream.RecvMsg(frame); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if len(frame.Value) == 0 {
+			continue
+		}
+		out := stdout
+		if frame.Value[0] == Stderr {
+			out = stderr
+		}
+		if _, err := out.Write(frame.Value[1:]); err != nil {
+			return err
+		}
+	}
+}
+
+// streamHandler serves a single call of the stream service.
+func streamHandler(srv interface{}, stream grpc.ServerStream) error {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		for {
+			frame := new(wrapperspb.BytesValue)
+			if err := stream.RecvMsg(frame); err == io.EOF {
+				pw.Close()
+				return
+			} else if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if len(frame.Value) == 0 || frame.Value[0] != Stdin {
+				continue
+			}
+			if _, err := pw.Write(frame.Value[1:]); err != nil {
+				return
+			}
+		}
+	}()
+
+	// SendMsg must not be called concurrently
+	mutex := &sync.Mutex{}
+	out := &frameWriter{stream: stream, id: Stdout, mutex: mutex}
+	errOut := &frameWriter{stream: stream, id: Stderr, mutex: mutex}
+	return srv.(StreamServer).Stream(stream.Context(), pr, out, errOut)
+}
+
+// frameWriter sends everything written to it as frames of a single stream.
+type frameWriter struct {
+	stream interface {
+		SendMsg(m interface{}) error
+	}
+	id    byte
+	mutex *sync.Mutex
+}
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	if w.mutex != nil {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+	}
+	value := make([]byte, len(p)+1)
+	value[0] = w.id
+	copy(value[1:], p)
+	if err := w.stream.SendMsg(&wrapperspb.BytesValue{Value: value}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,97 @@
+package support
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+var ErrConnectWithData = errors.New("Data cannot be sent with an extended CONNECT")
+
+// hijackExtendedConnect establishes the bidirectional stream using an HTTP/2 extended CONNECT (RFC 8441)
+// and streams data from/to the streams in the options of the given session.
+func hijackExtendedConnect(s *Session, ep *neturl.URL) error {
+	options := s.options
+	if options.Data != nil {
+		return ErrConnectWithData
+	}
+
+	conn, err := dialSessionH2(s, ep)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	transport := &http2.Transport{}
+	clientconn, err := transport.NewClientConn(conn)
+	if err != nil {
+		return err
+	}
+	defer clientconn.Close()
+
+	req, err := createHijackHttpRequest(options)
+	if err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	req.Method = http.MethodConnect
+	req.Body = pr
+	req.ContentLength = -1
+	// Connection specific headers are not allowed in HTTP/2
+	req.Header.Del("Connection")
+	req.Header.Del("Upgrade")
+	req.Header.Set(":protocol", options.ConnectProtocol)
+	if options.SigV4 != nil {
+		// The request is signed again for the changed method, the stream is not part of the signature
+		options.SigV4.sign(req.Header, req.Method, req.URL, requestHost(req), nil, time.Now())
+	}
+
+	s.injectTrace(req.Header)
+	span := s.startSpan(SpanHTTPHandshake)
+	dumpRequest(options, req)
+	res, err := clientconn.RoundTrip(req)
+	dumpResponse(options, res)
+	s.endHandshakeSpan(span, res, err)
+	storeCookies(options, req, res)
+	if err != nil || res.StatusCode > 299 {
+		pw.Close()
+		if options.ErrorHandler != nil {
+			if err := options.ErrorHandler(res, err); err != nil {
+				return err
+			}
+			return nil
+		}
+
+		return err
+	}
+	defer res.Body.Close()
+	return streamUpgraded(s, &connectStream{PipeWriter: pw, body: res.Body, conn: conn}, res)
+}
+
+// connectStream is the stream of an extended CONNECT, reading the response body and writing the request body.
+// CloseWrite ends the request body, signalling the end of the input stream to the server.
+type connectStream struct {
+	*io.PipeWriter
+	body io.ReadCloser
+	conn net.Conn
+}
+
+func (s *connectStream) Read(p []byte) (int, error) {
+	return s.body.Read(p)
+}
+
+func (s *connectStream) CloseWrite() error {
+	return s.PipeWriter.Close()
+}
+
+// Close ends both bodies and closes the connection, which carries only this stream.
+func (s *connectStream) Close() error {
+	s.PipeWriter.Close()
+	s.body.Close()
+	return s.conn.Close()
+}
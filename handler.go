@@ -0,0 +1,108 @@
+package support
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// StreamHandlerFunc handles a hijacked stream: it reads the input stream of the client from in and writes
+// its output and error streams to out and errW. A returned *ExitError is reported as exit code of the stream,
+// any other error is written to the error stream.
+type StreamHandlerFunc func(ctx context.Context, in io.Reader, out, errW io.Writer) error
+
+// HijackHandler returns an http.Handler that hijacks the connection (or falls back to a flushed response for HTTP/2,
+// see HijackServerOptions.Request), writes the response (101 if the client asked for an upgrade, 200 otherwise), multiplexes out and errW in the format clients read with DockerTermProtocol,
+// calls fn and sends the exit frame if the client asked for one (see ExitCodeFrame). The streams are closed when fn returns.
+// The context passed to fn is cancelled when fn returns or Shutdown is called.
+// The streams are set up according to the query parameters of the request (see ParseStreamConfig), in TTY mode
+// the output is sent raw. The config is available to fn using StreamConfigFromContext.
+// WebSocket upgrade requests are served over binary messages with the same semantics, or using the channels
+// of the v4.channel.k8s.io protocol if the client negotiated it (see ChannelProtocol).
+// Clients accepting text/event-stream get the output as Server-Sent Events without input (see NewSSEWriter).
+// The identity of a verified TLS client certificate is available to fn using ClientIdentityFromContext.
+func HijackHandler(fn StreamHandlerFunc) http.Handler {
+	return fn
+}
+
+// ServeHTTP implements http.Handler, see HijackHandler.
+func (fn StreamHandlerFunc) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	req = withClientIdentity(req)
+	if isWebSocketRequest(req) {
+		fn.serveWebSocket(w, req)
+		return
+	}
+	if isSSERequest(req) {
+		fn.serveSSE(w, req)
+		return
+	}
+	config, err := ParseStreamConfig(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in, out, err := HijackServerWithOptions(w, HijackServerOptions{StatusCode: responseStatusCode(req), Request: req})
+	if err != nil {
+		hijackError(w, err)
+		return
+	}
+	defer in.Close()
+	defer CloseStreams(in, out)
+	fn.serveStd(req, config, in, out)
+}
+
+// serveStd calls fn with the streams of the given config on the given hijacked streams and sends the exit frame
+// if requested (and the output is multiplexed).
+func (fn StreamHandlerFunc) serveStd(req *http.Request, config StreamConfig, in io.Reader, out io.Writer) {
+	// The request context is cancelled by net/http once the hijacked input stream ends
+	ctx, cancel := DefaultConnRegistry.notify(context.WithValue(context.WithoutCancel(req.Context()), streamConfigContextKey{}, config))
+	defer cancel()
+
+	output := &syncWriter{w: out}
+	in, stdout, stderr := config.streams(in, output)
+	status := handlerExitStatus(fn(ctx, in, stdout, stderr), stderr)
+	if ExitCodeRequested(req) && !config.TTY {
+		writeExitStatus(output, status)
+	}
+}
+
+// handlerExitStatus returns the exit status for the given error returned by a StreamHandlerFunc, writing errors
+// other than *ExitError to the given error stream.
+func handlerExitStatus(err error, stderr io.Writer) ExitStatus {
+	var status ExitStatus
+	if err != nil {
+		var exitErr *ExitError
+		if errors.As(err, &exitErr) {
+			status = ExitStatus{ExitCode: exitErr.Code, Signal: exitErr.Signal}
+		} else {
+			status.ExitCode = 1
+			fmt.Fprintln(stderr, err.Error())
+		}
+	}
+	return status
+}
+
+// hijackError writes the given error of a failed hijack as response.
+func hijackError(w http.ResponseWriter, err error) {
+	var rejected *rejectedError
+	if err == ErrHijackUnsupported || errors.As(err, &rejected) {
+		// The response has already been written
+		return
+	}
+	statusCode := http.StatusInternalServerError
+	if err == ErrServerShutdown || err == ErrTooManyConnections {
+		statusCode = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), statusCode)
+}
+
+// responseStatusCode returns the status code of the response to the given request, 101 if the client asked
+// for an upgrade and 200 otherwise.
+func responseStatusCode(req *http.Request) int {
+	if req.Header.Get("Upgrade") != "" {
+		return http.StatusSwitchingProtocols
+	}
+	return http.StatusOK
+}
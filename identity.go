@@ -0,0 +1,58 @@
+package support
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	neturl "net/url"
+)
+
+// ClientIdentity is the identity of a client that authenticated with a verified TLS client certificate.
+type ClientIdentity struct {
+	CommonName     string
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*neturl.URL
+	Certificate    *x509.Certificate // The verified leaf certificate
+}
+
+// ClientIdentityFromRequest returns the identity of the verified client certificate of the TLS connection of the
+// given request. ok is false if the request was not sent over TLS or without a certificate verified by the server
+// (see tls.Config.ClientAuth), unverified certificates are never returned.
+func ClientIdentityFromRequest(req *http.Request) (identity *ClientIdentity, ok bool) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	cert := req.TLS.VerifiedChains[0][0]
+	return &ClientIdentity{
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    cert.IPAddresses,
+		URIs:           cert.URIs,
+		Certificate:    cert,
+	}, true
+}
+
+// clientIdentityContextKey is the context key of the ClientIdentity passed to a StreamHandlerFunc.
+type clientIdentityContextKey struct{}
+
+// withClientIdentity returns the given request with the identity of its client certificate (if any) stored in
+// its context, so it is available to stream handlers using ClientIdentityFromContext.
+func withClientIdentity(req *http.Request) *http.Request {
+	identity, ok := ClientIdentityFromRequest(req)
+	if !ok {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), clientIdentityContextKey{}, identity))
+}
+
+// ClientIdentityFromContext returns the identity of the verified client certificate of the request served by
+// a StreamHandlerFunc (or a handler behind HijackMiddleware), e.g. for per-identity authorization and audit.
+// ok is false if the client did not authenticate with a verified certificate.
+func ClientIdentityFromContext(ctx context.Context) (identity *ClientIdentity, ok bool) {
+	identity, ok = ctx.Value(clientIdentityContextKey{}).(*ClientIdentity)
+	return identity, ok
+}
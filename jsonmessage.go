@@ -0,0 +1,89 @@
+package support
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// JSONMessage is a progress or output message as streamed by docker build, pull and push endpoints.
+type JSONMessage struct {
+	Stream         string           `json:"stream,omitempty"`
+	Status         string           `json:"status,omitempty"`
+	Progress       string           `json:"progress,omitempty"`
+	ProgressDetail *JSONProgress    `json:"progressDetail,omitempty"`
+	ID             string           `json:"id,omitempty"`
+	ErrorDetail    *JSONError       `json:"errorDetail,omitempty"`
+	ErrorMessage   string           `json:"error,omitempty"`
+	Aux            *json.RawMessage `json:"aux,omitempty"`
+}
+
+// JSONProgress describes the progress of a JSONMessage.
+type JSONProgress struct {
+	Current int64 `json:"current,omitempty"`
+	Total   int64 `json:"total,omitempty"`
+}
+
+// JSONError is an error embedded in a JSONMessage.
+type JSONError struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func (e *JSONError) Error() string {
+	return e.Message
+}
+
+// Err returns the error embedded in the message, if any.
+func (m *JSONMessage) Err() error {
+	if m.ErrorDetail != nil {
+		return m.ErrorDetail
+	}
+	if m.ErrorMessage != "" {
+		return errors.New(m.ErrorMessage)
+	}
+	return nil
+}
+
+// jsonMessageWriter parses everything written to it as newline-delimited JSON messages and passes them to a handler.
+// Embedded errors and errors of the handler abort the stream.
+type jsonMessageWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newJSONMessageWriter(handler func(msg JSONMessage) error) *jsonMessageWriter {
+	pr, pw := io.Pipe()
+	w := &jsonMessageWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		dec := json.NewDecoder(pr)
+		for {
+			var msg JSONMessage
+			err := dec.Decode(&msg)
+			if err == nil {
+				if err = msg.Err(); err == nil {
+					err = handler(msg)
+				}
+			}
+			if err == io.EOF {
+				w.done <- nil
+				return
+			} else if err != nil {
+				pr.CloseWithError(err)
+				w.done <- err
+				return
+			}
+		}
+	}()
+	return w
+}
+
+func (w *jsonMessageWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close ends the stream of messages and returns the first error that occurred while handling them.
+func (w *jsonMessageWriter) Close() error {
+	w.pw.Close()
+	return <-w.done
+}
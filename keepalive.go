@@ -0,0 +1,76 @@
+package support
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// KeepaliveFrame is the default ping of a KeepaliveWriter: an empty stdout frame of the multiplexed format,
+// which clients with DockerTermProtocol ignore.
+var KeepaliveFrame = []byte{byte(StdoutStream), 0, 0, 0, 0, 0, 0, 0}
+
+// SSEKeepalive is a ping for Server-Sent Events streams: a comment line ignored by clients.
+var SSEKeepalive = []byte(":ping\n\n")
+
+// KeepaliveWriter writes a ping to an otherwise idle hijacked stream in a fixed interval, keeping intermediaries
+// (e.g. load balancers or proxies) from closing the connection. Everything written to it is passed on.
+// It is safe for concurrent use.
+type KeepaliveWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+	ping  []byte
+	last  time.Time
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// NewKeepaliveWriter creates a writer to w that writes the given ping (KeepaliveFrame if nil) whenever nothing has been
+// written for the given interval. Close must be called to stop it, writing the ping also stops once it fails.
+// Raw (TTY) streams have no frame that clients ignore, so it should only be used with framed formats.
+func NewKeepaliveWriter(w io.Writer, interval time.Duration, ping []byte) *KeepaliveWriter {
+	if ping == nil {
+		ping = KeepaliveFrame
+	}
+	k := &KeepaliveWriter{w: w, ping: ping, last: time.Now(), stop: make(chan struct{})}
+	go k.run(interval)
+	return k
+}
+
+// run writes the ping until stopped.
+func (k *KeepaliveWriter) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.stop:
+			return
+		case now := <-ticker.C:
+			k.mutex.Lock()
+			var err error
+			if now.Sub(k.last) >= interval {
+				_, err = k.w.Write(k.ping)
+				k.last = now
+			}
+			k.mutex.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (k *KeepaliveWriter) Write(p []byte) (int, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.last = time.Now()
+	return k.w.Write(p)
+}
+
+// Close stops writing the ping, the underlying writer is not closed.
+func (k *KeepaliveWriter) Close() error {
+	k.once.Do(func() {
+		close(k.stop)
+	})
+	return nil
+}
@@ -0,0 +1,57 @@
+package support
+
+import (
+	docker "github.com/giantswarm/hijack-stream-support/docker"
+)
+
+// LeveledLogger is a Logger (see HijackHttpOptions.Log) with additional levels. Loggers set in the options may
+// implement it to receive failures like broken streams or failed close calls at the Warn or Error level, messages
+// of loggers only implementing Debugf are all logged at debug level, prefixed with their level.
+type LeveledLogger interface {
+	docker.Logger
+	Infof(msg string, args ...interface{})
+	Warnf(msg string, args ...interface{})
+	Errorf(msg string, args ...interface{})
+}
+
+// StructuredLogger is a LeveledLogger supporting attributes, e.g. of slog (see NewSlogLogger). Sessions add their
+// endpoint, request ID and the direction and bytes of finished streams as attributes.
+type StructuredLogger interface {
+	LeveledLogger
+	// With returns a logger adding the given attributes (alternating keys and values) to all messages.
+	With(keysAndValues ...interface{}) StructuredLogger
+}
+
+// withAttrs returns the given logger adding the given attributes if it is a StructuredLogger, the logger itself
+// otherwise.
+func withAttrs(log docker.Logger, keysAndValues ...interface{}) docker.Logger {
+	if l, ok := log.(StructuredLogger); ok {
+		return l.With(keysAndValues...)
+	}
+	return log
+}
+
+// levels returns the given logger as LeveledLogger.
+func levels(log docker.Logger) LeveledLogger {
+	if l, ok := log.(LeveledLogger); ok {
+		return l
+	}
+	return &debugLogger{log}
+}
+
+// debugLogger logs all levels of a LeveledLogger using the Debugf of a Logger.
+type debugLogger struct {
+	docker.Logger
+}
+
+func (l *debugLogger) Infof(msg string, args ...interface{}) {
+	l.Debugf("INFO "+msg, args...)
+}
+
+func (l *debugLogger) Warnf(msg string, args ...interface{}) {
+	l.Debugf("WARN "+msg, args...)
+}
+
+func (l *debugLogger) Errorf(msg string, args ...interface{}) {
+	l.Debugf("ERROR "+msg, args...)
+}
@@ -0,0 +1,54 @@
+// Package logruslog adapts logrus loggers to the Log of HijackHttpOptions:
+//
+//	hijackOpts.Log = logruslog.New(logrus.StandardLogger())
+//
+// The attributes of sessions (e.g. endpoint, direction and bytes) are logged as logrus fields.
+package logruslog
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	support "github.com/giantswarm/hijack-stream-support"
+)
+
+// Logger is a support.StructuredLogger backed by a logrus logger or entry.
+type Logger struct {
+	logger logrus.FieldLogger
+}
+
+// New returns a logger logging to the given logrus logger or entry (e.g. with fields of the caller).
+func New(logger logrus.FieldLogger) *Logger {
+	return &Logger{logger: logger}
+}
+
+func (l *Logger) Debugf(msg string, args ...interface{}) {
+	l.logger.Debugf(msg, args...)
+}
+
+func (l *Logger) Infof(msg string, args ...interface{}) {
+	l.logger.Infof(msg, args...)
+}
+
+func (l *Logger) Warnf(msg string, args ...interface{}) {
+	l.logger.Warnf(msg, args...)
+}
+
+func (l *Logger) Errorf(msg string, args ...interface{}) {
+	l.logger.Errorf(msg, args...)
+}
+
+// With implements support.StructuredLogger. Keys that are no strings are formatted using fmt.Sprint, a key without
+// value is logged with a nil value.
+func (l *Logger) With(keysAndValues ...interface{}) support.StructuredLogger {
+	fields := make(logrus.Fields, len(keysAndValues)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		var value interface{}
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+		fields[fmt.Sprint(keysAndValues[i])] = value
+	}
+	return &Logger{logger: l.logger.WithFields(fields)}
+}
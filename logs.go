@@ -0,0 +1,150 @@
+package support
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	docker "github.com/giantswarm/hijack-stream-support/docker"
+)
+
+type LogsOptions struct {
+	Follow       bool                     // If set, new output is streamed until the container stops.
+	Since        time.Time                // If set, only output since this time is returned.
+	Tail         string                   // Number of lines to return from the end of the logs, or "all".
+	Timestamps   bool                     // If set, every line is prefixed with its timestamp.
+	OutputStream io.Writer                // If set, stdout of the container is returned.
+	ErrorStream  io.Writer                // If set, stderr of the container is returned.
+	LineHandler  func(line LogLine) error // If set, the output is passed line by line to this handler instead of the streams.
+	APIVersion   string                   // If set, the docker API of this version (e.g. "1.41") is used. Use "auto" to negotiate the version with the daemon.
+	Header       http.Header
+	Log          docker.Logger
+}
+
+// LogLine is a single line of container output passed to the LineHandler.
+type LogLine struct {
+	Stream    string    // "stdout" or "stderr"
+	Timestamp time.Time // Only set if Timestamps is enabled.
+	Text      string    // Content of the line without the trailing newline.
+}
+
+// FollowLogs fetches the logs of the container with the given ID using the docker API at the given endpoint
+// and writes them to the streams (or the LineHandler) in the given options.
+func FollowLogs(endpoint, containerID string, options LogsOptions) error {
+	if containerID == "" {
+		return ErrMissingContainerID
+	}
+	if options.Log == nil {
+		options.Log = &logIgnore{}
+	}
+	client, err := newAPIClient(endpoint, options.Header)
+	if err != nil {
+		return err
+	}
+	if client.version, err = resolveAPIVersion(endpoint, options.APIVersion, options.Header); err != nil {
+		return err
+	}
+	containerPath := "/containers/" + neturl.PathEscape(containerID)
+
+	// Output of containers with a TTY is not multiplexed
+	var inspect struct {
+		Config struct {
+			Tty bool
+		}
+	}
+	if err := client.do("GET", containerPath+"/json", nil, &inspect); err != nil {
+		return err
+	}
+
+	stdout, stderr := options.OutputStream, options.ErrorStream
+	if options.LineHandler != nil {
+		stdoutLines := &lineWriter{stream: "stdout", timestamps: options.Timestamps, handler: options.LineHandler}
+		stderrLines := &lineWriter{stream: "stderr", timestamps: options.Timestamps, handler: options.LineHandler}
+		defer stdoutLines.Flush()
+		defer stderrLines.Flush()
+		stdout, stderr = stdoutLines, stderrLines
+	}
+
+	query := neturl.Values{}
+	setBoolParam(query, "stdout", stdout != nil)
+	setBoolParam(query, "stderr", stderr != nil)
+	setBoolParam(query, "follow", options.Follow)
+	setBoolParam(query, "timestamps", options.Timestamps)
+	if !options.Since.IsZero() {
+		query.Set("since", strconv.FormatInt(options.Since.Unix(), 10))
+	}
+	if options.Tail != "" {
+		query.Set("tail", options.Tail)
+	}
+
+	res, err := client.open("GET", containerPath+"/logs?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+	if inspect.Config.Tty {
+		_, err = io.Copy(stdout, res.Body)
+	} else {
+		_, err = docker.StdCopy(stdout, stderr, res.Body, options.Log)
+	}
+	return err
+}
+
+// lineWriter splits everything written to it into lines and passes them to a handler.
+type lineWriter struct {
+	stream     string
+	timestamps bool
+	handler    func(line LogLine) error
+	buf        []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := string(w.buf[:i])
+		w.buf = w.buf[i+1:]
+		if err := w.handler(w.parse(line)); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Flush passes the remaining incomplete line (if any) to the handler.
+func (w *lineWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := string(w.buf)
+	w.buf = nil
+	return w.handler(w.parse(line))
+}
+
+// parse converts a line of output into a LogLine, splitting off the timestamp prefix if enabled.
+func (w *lineWriter) parse(text string) LogLine {
+	line := LogLine{Stream: w.stream, Text: text}
+	if w.timestamps {
+		if i := strings.IndexByte(text, ' '); i > 0 {
+			if ts, err := time.Parse(time.RFC3339Nano, text[:i]); err == nil {
+				line.Timestamp = ts
+				line.Text = text[i+1:]
+			}
+		}
+	}
+	return line
+}
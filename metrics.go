@@ -0,0 +1,120 @@
+package support
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Error classes of the hijack_client_session_errors_total metric. Other errors are classified by the phase of the
+// session they occurred in: dial, tls_handshake, http_handshake or stream.
+const (
+	ErrorClassRequest = "request" // The request could not be prepared, e.g. an invalid URL
+	ErrorClassExit    = "exit"    // The remote process exited with a non-zero exit code (see ExitError)
+	ErrorClassClosed  = "closed"  // The server closed the stream with a close reason (see ClosedError)
+)
+
+// Metrics collects Prometheus metrics of the client sessions it is set for (see HijackHttpOptions.Metrics).
+// It is a prometheus.Collector, register it on a prometheus.Registerer to expose the metrics.
+// A Metrics can be shared by any number of sessions.
+type Metrics struct {
+	sessionsStarted   prometheus.Counter
+	sessionsActive    prometheus.Gauge
+	handshakeDuration prometheus.Histogram
+	sessionDuration   prometheus.Histogram
+	bytes             *prometheus.CounterVec
+	errors            *prometheus.CounterVec
+}
+
+// NewMetrics creates the metrics of client sessions.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		sessionsStarted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "hijack", Subsystem: "client", Name: "sessions_started_total",
+			Help: "Number of started sessions.",
+		}),
+		sessionsActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "hijack", Subsystem: "client", Name: "sessions_active",
+			Help: "Number of sessions that have not finished yet.",
+		}),
+		handshakeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "hijack", Subsystem: "client", Name: "handshake_duration_seconds",
+			Help:    "Duration of the HTTP handshake of sessions, from sending the request until the response has been received.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		sessionDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "hijack", Subsystem: "client", Name: "session_duration_seconds",
+			Help:    "Duration of finished sessions.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 4, 10),
+		}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "hijack", Subsystem: "client", Name: "stream_bytes_total",
+			Help: "Bytes streamed over hijacked connections by direction (in: received, out: sent).",
+		}, []string{"direction"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "hijack", Subsystem: "client", Name: "session_errors_total",
+			Help: "Number of failed sessions by error class.",
+		}, []string{"class"}),
+	}
+}
+
+// collectors returns the collectors of the metrics.
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.sessionsStarted, m.sessionsActive, m.handshakeDuration, m.sessionDuration, m.bytes, m.errors}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// sessionStarted records the start of a session.
+func (m *Metrics) sessionStarted() {
+	if m == nil {
+		return
+	}
+	m.sessionsStarted.Inc()
+	m.sessionsActive.Inc()
+}
+
+// handshakeDone records the duration of an HTTP handshake.
+func (m *Metrics) handshakeDone(duration time.Duration) {
+	if m != nil {
+		m.handshakeDuration.Observe(duration.Seconds())
+	}
+}
+
+// sessionEnded records the end of the given session with the given final error.
+func (m *Metrics) sessionEnded(s *Session, err error) {
+	if m == nil {
+		return
+	}
+	m.sessionsActive.Dec()
+	m.sessionDuration.Observe(time.Since(s.started).Seconds())
+	m.bytes.WithLabelValues(DirectionIn).Add(float64(atomic.LoadInt64(&s.bytesIn)))
+	m.bytes.WithLabelValues(DirectionOut).Add(float64(atomic.LoadInt64(&s.bytesOut)))
+	if err == nil {
+		return
+	}
+	var exitErr *ExitError
+	var closedErr *ClosedError
+	class := s.phase
+	switch {
+	case errors.As(err, &exitErr):
+		class = ErrorClassExit
+	case errors.As(err, &closedErr):
+		class = ErrorClassClosed
+	}
+	m.errors.WithLabelValues(class).Inc()
+}
@@ -0,0 +1,47 @@
+package support
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// streamsContextKey is the context key of the hijacked streams.
+type streamsContextKey struct{}
+
+// hijackedStreams are the streams stored in the context by HijackMiddleware.
+type hijackedStreams struct {
+	in  io.ReadCloser
+	out io.Writer
+}
+
+// HijackMiddleware returns middleware that hijacks the connection, writes the response (101 if the client asked
+// for an upgrade, 200 otherwise) and calls next with the hijacked streams in the request context, see StreamsFromContext.
+// The streams are closed when next returns. Downstream handlers must not use the response writer.
+// Middleware that must be able to reject the request (e.g. authentication) has to run before it.
+// The identity of a verified TLS client certificate is available using ClientIdentityFromContext.
+func HijackMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		in, out, err := HijackServerWithOptions(w, HijackServerOptions{StatusCode: responseStatusCode(req), Request: req})
+		if err != nil {
+			hijackError(w, err)
+			return
+		}
+		defer in.Close()
+		defer CloseStreams(in, out)
+
+		// The request context is cancelled by net/http once the hijacked input stream ends
+		ctx := context.WithValue(context.WithoutCancel(withClientIdentity(req).Context()), streamsContextKey{}, &hijackedStreams{in: in, out: out})
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// StreamsFromContext returns the hijacked input and output streams stored in the given context by HijackMiddleware.
+// ok is false if the context does not contain hijacked streams.
+func StreamsFromContext(ctx context.Context) (in io.ReadCloser, out io.Writer, ok bool) {
+	streams, ok := ctx.Value(streamsContextKey{}).(*hijackedStreams)
+	if !ok {
+		return nil, nil, false
+	}
+	return streams.in, streams.out, true
+}
@@ -0,0 +1,60 @@
+package support
+
+import (
+	"time"
+
+	"golang.org/x/term"
+)
+
+// ResizeDebounce is the time MonitorTerminalSize waits for further size changes before resizing the session.
+var ResizeDebounce = 100 * time.Millisecond
+
+// MonitorTerminalSize resizes the TTY of the given session to the size of the local terminal with the given file
+// descriptor (e.g. int(os.Stdout.Fd())), initially and whenever the terminal size changes.
+// Monitoring stops when the session is done.
+func MonitorTerminalSize(s *Session, fd int) {
+	var width, height int
+	resize := func() {
+		w, h, err := term.GetSize(fd)
+		if err != nil {
+			levels(s.options.Log).Warnf("Getting terminal size failed %#v", err)
+			return
+		}
+		if w == width && h == height {
+			return
+		}
+		width, height = w, h
+		if err := s.Resize(uint(width), uint(height)); err != nil {
+			levels(s.options.Log).Warnf("Resize failed %#v", err)
+		}
+	}
+
+	resize()
+	changes := make(chan struct{}, 1)
+	stop := watchTerminalSize(changes)
+	go func() {
+		defer stop()
+		for {
+			select {
+			case <-s.Done():
+				return
+			case <-changes:
+			}
+			// Debounce changes while the window is being resized
+			timer := time.NewTimer(ResizeDebounce)
+		debounce:
+			for {
+				select {
+				case <-s.Done():
+					timer.Stop()
+					return
+				case <-changes:
+					timer.Reset(ResizeDebounce)
+				case <-timer.C:
+					break debounce
+				}
+			}
+			resize()
+		}
+	}()
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+
+package support
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchTerminalSize notifies the given channel when the terminal size changes (SIGWINCH).
+// The returned function stops watching.
+func watchTerminalSize(changes chan<- struct{}) func() {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGWINCH)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-signals:
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
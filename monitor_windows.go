@@ -0,0 +1,32 @@
+package support
+
+import (
+	"time"
+)
+
+// terminalSizePollInterval is the interval at which the console size is polled, since windows has no SIGWINCH.
+const terminalSizePollInterval = 250 * time.Millisecond
+
+// watchTerminalSize notifies the given channel on every poll interval, unchanged sizes are skipped when resizing.
+// The returned function stops watching.
+func watchTerminalSize(changes chan<- struct{}) func() {
+	ticker := time.NewTicker(terminalSizePollInterval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
@@ -0,0 +1,46 @@
+package support
+
+import (
+	"encoding/binary"
+	"io"
+
+	docker "github.com/giantswarm/hijack-stream-support/docker"
+)
+
+// StdStream identifies a stream in the multiplexed format used with DockerTermProtocol.
+type StdStream byte
+
+const (
+	StdinStream  StdStream = 0
+	StdoutStream StdStream = 1
+	StderrStream StdStream = 2
+)
+
+// NewStdWriter returns a writer that frames everything written to it as the given stream of the multiplexed format
+// and writes the frames to w. Servers use it to write stdout and stderr that clients with DockerTermProtocol demultiplex.
+// Every frame is written to w with a single write, so frames are never cut by wrappers of w (e.g. byte quotas).
+func NewStdWriter(w io.Writer, stream StdStream) io.Writer {
+	return &frameWriter{w: w, stream: stream}
+}
+
+// frameWriter frames everything written to it as a stream of the multiplexed format.
+type frameWriter struct {
+	w      io.Writer
+	stream StdStream
+}
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	frame := make([]byte, docker.StdWriterPrefixLen+len(p))
+	frame[0] = byte(w.stream)
+	binary.BigEndian.PutUint32(frame[4:], uint32(len(p)))
+	copy(frame[docker.StdWriterPrefixLen:], p)
+	n, err := w.w.Write(frame)
+	return max(n-docker.StdWriterPrefixLen, 0), err
+}
+
+// NewStdWriters returns the stdout and stderr writers of the multiplexed format for the given hijacked writer, as
+// expected by clients with DockerTermProtocol. Writes of both are serialized, so they are safe for concurrent use.
+func NewStdWriters(w io.Writer) (stdout, stderr io.Writer) {
+	output := &syncWriter{w: w}
+	return NewStdWriter(output, StdoutStream), NewStdWriter(output, StderrStream)
+}
@@ -0,0 +1,31 @@
+package support
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStdWritersRoundTrip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		in, out, err := HijackServerResponse(w, http.StatusSwitchingProtocols, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer CloseStreams(in, out)
+		stdout, stderr := NewStdWriters(out)
+		io.WriteString(stdout, "out")
+		io.WriteString(stderr, "err")
+		io.WriteString(stdout, "put")
+	}))
+	defer srv.Close()
+	var stdout, stderr bytes.Buffer
+	err := HijackHttpRequest(HijackHttpOptions{Method: "POST", Url: srv.URL, DockerTermProtocol: true,
+		OutputStream: &stdout, ErrorStream: &stderr})
+	if err != nil || stdout.String() != "output" || stderr.String() != "err" {
+		t.Fatalf("%q %q %v", stdout.String(), stderr.String(), err)
+	}
+}
@@ -0,0 +1,87 @@
+package support
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/hashicorp/yamux"
+)
+
+var ErrNotMultiplexed = errors.New("Session is not multiplexed")
+
+// streamMux runs a yamux client session over the given hijacked connection until it is closed.
+func streamMux(s *Session, conn io.ReadWriteCloser) error {
+	if s.countsBytes() {
+		conn = &countedConn{
+			Reader: s.countReader(conn, &s.bytesIn, DirectionIn),
+			Writer: s.countWriter(conn, &s.bytesOut, DirectionOut),
+			Closer: conn,
+		}
+	}
+	mux, err := yamux.Client(conn, s.options.MuxConfig)
+	if err != nil {
+		return err
+	}
+	defer mux.Close()
+	s.mutex.Lock()
+	s.mux = mux
+	s.mutex.Unlock()
+	s.upgrade(mux, nil)
+
+	<-mux.CloseChan()
+	return nil
+}
+
+// countedConn is a connection whose reads and writes are counted.
+type countedConn struct {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+// OpenStream opens a new logical stream on a session with the Multiplex option.
+func (s *Session) OpenStream() (net.Conn, error) {
+	mux, err := s.getMux()
+	if err != nil {
+		return nil, err
+	}
+	return mux.Open()
+}
+
+// AcceptStream waits for the server to open a new logical stream on a session with the Multiplex option.
+func (s *Session) AcceptStream() (net.Conn, error) {
+	mux, err := s.getMux()
+	if err != nil {
+		return nil, err
+	}
+	return mux.Accept()
+}
+
+// getMux returns the yamux session of a multiplexed session.
+func (s *Session) getMux() (*yamux.Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.mux == nil {
+		return nil, ErrNotMultiplexed
+	}
+	return s.mux, nil
+}
+
+// HijackServerMux hijacks the connection of the given response writer, sends the upgrade response
+// and runs a yamux server session over it. Use OpenStream and AcceptStream on the returned session to
+// exchange logical streams with a client using the Multiplex option. Config may be nil.
+func HijackServerMux(w http.ResponseWriter, config *yamux.Config) (*yamux.Session, error) {
+	in, _, err := HijackServerResponse(w, http.StatusSwitchingProtocols, nil)
+	if err != nil {
+		return nil, err
+	}
+	conn := in.(net.Conn)
+	mux, err := yamux.Server(conn, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return mux, nil
+}
@@ -0,0 +1,49 @@
+package support
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMultiplexRoundTrip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mux, err := HijackServerMux(w, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer mux.Close()
+		for {
+			stream, err := mux.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer stream.Close()
+				io.Copy(stream, stream)
+			}()
+		}
+	}))
+	defer srv.Close()
+	s, err := StartHijackHttpRequest(HijackHttpOptions{Method: "POST", Url: srv.URL, Multiplex: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, msg := range []string{"first", "second"} {
+		stream, err := s.OpenStream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(stream, msg); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, len(msg))
+		if _, err := io.ReadFull(stream, buf); err != nil || string(buf) != msg {
+			t.Fatalf("%q %v", buf, err)
+		}
+		stream.Close()
+	}
+}
@@ -0,0 +1,56 @@
+package support
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	neturl "net/url"
+	"strconv"
+	"sync"
+)
+
+// ErrNoDefaultPort is returned when dialing an URL without an explicit port whose scheme has no registered default port.
+var ErrNoDefaultPort = errors.New("No default port registered for scheme")
+
+var (
+	defaultPortsMutex sync.RWMutex
+	defaultPorts      = map[string]int{
+		"http":  80,
+		"https": 443,
+		"ws":    80,
+		"wss":   443,
+	}
+)
+
+// RegisterDefaultPort registers the port that is used when dialing an URL with the given scheme
+// that does not contain an explicit port (e.g. RegisterDefaultPort("tcp", 2376) for docker).
+func RegisterDefaultPort(scheme string, port int) {
+	defaultPortsMutex.Lock()
+	defer defaultPortsMutex.Unlock()
+	defaultPorts[scheme] = port
+}
+
+// defaultPort returns the registered default port for the given scheme and whether one is registered.
+func defaultPort(scheme string) (string, bool) {
+	defaultPortsMutex.RLock()
+	defer defaultPortsMutex.RUnlock()
+	port, ok := defaultPorts[scheme]
+	if !ok {
+		return "", false
+	}
+	return strconv.Itoa(port), true
+}
+
+// DialAddress returns the host:port address to dial for the given URL, adding the default port registered for its
+// scheme (see RegisterDefaultPort) if the URL has no explicit port. IPv6 literals are bracketed as needed.
+// ErrNoDefaultPort is returned if the URL has no port and no default port is registered for its scheme.
+func DialAddress(ep *neturl.URL) (string, error) {
+	if host, port, err := net.SplitHostPort(ep.Host); err == nil {
+		return net.JoinHostPort(host, port), nil
+	}
+	port, ok := defaultPort(ep.Scheme)
+	if !ok {
+		return "", fmt.Errorf("%w %s", ErrNoDefaultPort, ep.Scheme)
+	}
+	return net.JoinHostPort(ep.Hostname(), port), nil
+}
@@ -0,0 +1,155 @@
+package support
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	neturl "net/url"
+	"strings"
+)
+
+// hopHeaders are the hop-by-hop headers that are not forwarded by a HijackProxy (apart from the upgrade headers).
+var hopHeaders = []string{
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+}
+
+// HijackProxy is a reverse proxy for hijacked streams: it forwards an incoming hijack or upgrade request to the
+// backend, and once the backend has accepted it, hijacks the incoming connection and splices both connections
+// together in both directions until both have ended. Responses rejecting the request are passed to the client.
+type HijackProxy struct {
+	Backend        *neturl.URL                                               // URL of the backend (any scheme supported by the client, see RegisterTransport). The path of a request is appended to its path.
+	Director       func(req *http.Request)                                   // If set, this function is called with the request sent to the backend, e.g. to rewrite its headers.
+	ModifyResponse func(res *http.Response) error                            // If set, this function is called with the response of the backend accepting the request before it is sent to the client. An error is handled by the ErrorHandler.
+	ErrorHandler   func(w http.ResponseWriter, req *http.Request, err error) // If set, this function answers requests that could not be forwarded, defaults to 502 Bad Gateway.
+}
+
+// NewHijackProxy creates a proxy forwarding requests to the given backend.
+func NewHijackProxy(backend *neturl.URL) *HijackProxy {
+	return &HijackProxy{Backend: backend}
+}
+
+// ServeHTTP implements http.Handler.
+func (p *HijackProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	out := p.backendRequest(req)
+	conn, err := dial(out.URL)
+	if err != nil {
+		p.error(w, req, err)
+		return
+	}
+	clientconn := httputil.NewClientConn(conn, nil)
+	defer clientconn.Close()
+	res, err := clientconn.Do(out)
+	if err != nil && err != httputil.ErrPersistEOF {
+		p.error(w, req, err)
+		return
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols && (res.StatusCode < 200 || res.StatusCode > 299) {
+		// The backend rejected the request, pass its response to the client
+		defer res.Body.Close()
+		copyResponseHeader(w.Header(), res.Header)
+		w.WriteHeader(res.StatusCode)
+		io.Copy(w, res.Body)
+		return
+	}
+	if p.ModifyResponse != nil {
+		if err := p.ModifyResponse(res); err != nil {
+			p.error(w, req, err)
+			return
+		}
+	}
+
+	rwc, br := clientconn.Hijack()
+	backend := &bufferedConn{rwc, br}
+	defer backend.Close()
+	header := make(http.Header)
+	copyResponseHeader(header, res.Header)
+	header.Del("Content-Length")
+	in, _, err := HijackServerWithOptions(w, HijackServerOptions{StatusCode: res.StatusCode, Header: header})
+	if err != nil {
+		hijackError(w, err)
+		return
+	}
+	defer in.Close()
+	splice(in.(net.Conn), backend)
+}
+
+// backendRequest returns the request sent to the backend for the given incoming request.
+func (p *HijackProxy) backendRequest(req *http.Request) *http.Request {
+	out := req.Clone(req.Context())
+	target := *p.Backend
+	target.Path = strings.TrimSuffix(p.Backend.Path, "/") + "/" + strings.TrimPrefix(req.URL.Path, "/")
+	target.RawPath = ""
+	target.RawQuery = req.URL.RawQuery
+	out.URL = &target
+	out.Host = p.Backend.Host
+	out.RequestURI = ""
+	if req.ContentLength == 0 {
+		out.Body = nil
+	}
+
+	// Hop-by-hop headers only apply to the connection to the proxy, apart from the upgrade itself
+	upgrade := out.Header.Get("Upgrade")
+	for _, name := range strings.Split(out.Header.Get("Connection"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			out.Header.Del(name)
+		}
+	}
+	for _, name := range hopHeaders {
+		out.Header.Del(name)
+	}
+	out.Header.Set("Connection", "Upgrade")
+	if upgrade == "" {
+		upgrade = "tcp"
+	}
+	out.Header.Set("Upgrade", upgrade)
+
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
+			host = prior + ", " + host
+		}
+		out.Header.Set("X-Forwarded-For", host)
+	}
+	out.Header.Set("X-Forwarded-Host", req.Host)
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	out.Header.Set("X-Forwarded-Proto", proto)
+
+	if p.Director != nil {
+		p.Director(out)
+	}
+	return out
+}
+
+// error answers a request that could not be forwarded.
+func (p *HijackProxy) error(w http.ResponseWriter, req *http.Request, err error) {
+	if p.ErrorHandler != nil {
+		p.ErrorHandler(w, req, err)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
+
+// copyResponseHeader copies the given response headers of the backend, apart from hop-by-hop headers.
+func copyResponseHeader(dst, src http.Header) {
+	for k, values := range src {
+		dst[k] = append([]string(nil), values...)
+	}
+	for _, name := range strings.Split(src.Get("Connection"), ",") {
+		if name = strings.TrimSpace(name); name != "" && !strings.EqualFold(name, "Upgrade") {
+			dst.Del(name)
+		}
+	}
+	dst.Del("Connection")
+	for _, name := range hopHeaders {
+		dst.Del(name)
+	}
+}
@@ -0,0 +1,241 @@
+package support
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyHeaderTimeout is the time a connection accepted by a listener of NewProxyProtocolListener has to send its
+// PROXY protocol header.
+const ProxyHeaderTimeout = 10 * time.Second
+
+var ErrInvalidProxyHeader = errors.New("Invalid PROXY protocol header")
+
+// proxyV2Signature starts a PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// NewProxyProtocolListener returns a listener whose connections start with a PROXY protocol (v1 or v2) header,
+// as sent by L4 load balancers like HAProxy or AWS NLB. The RemoteAddr and LocalAddr of its connections are the
+// addresses of the original connection of the client, so they are used by the server helpers (e.g. for access logs
+// and RemoteIPKey). The header is read when the connection is first used, connections without a valid header fail.
+// Only use it behind a load balancer sending the header, since clients could otherwise spoof their address.
+func NewProxyProtocolListener(l net.Listener) net.Listener {
+	return &proxyListener{l}
+}
+
+// proxyListener is a listener of NewProxyProtocolListener.
+type proxyListener struct {
+	net.Listener
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	// The header is read lazily, so a slow client does not block accepting other connections
+	return &proxyConn{Conn: conn, br: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn is a connection starting with a PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+	br     *bufio.Reader
+	once   sync.Once
+	err    error
+	source net.Addr
+	dest   net.Addr
+}
+
+// readHeader reads the PROXY protocol header once.
+func (c *proxyConn) readHeader() error {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(ProxyHeaderTimeout))
+		c.source, c.dest, c.err = ReadProxyHeader(c.br)
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+	return c.err
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	if err := c.readHeader(); err != nil {
+		return 0, err
+	}
+	return c.br.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.readHeader() == nil && c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.readHeader() == nil && c.dest != nil {
+		return c.dest
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// ReadProxyHeader reads a PROXY protocol v1 or v2 header from the given reader and returns the source and
+// destination addresses of the proxied connection. Both are nil for headers without addresses (v1 UNKNOWN,
+// v2 LOCAL or unsupported address families), in which case the addresses of the connection itself apply.
+func ReadProxyHeader(br *bufio.Reader) (source, dest net.Addr, err error) {
+	start, err := br.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(start, proxyV2Signature) {
+		return readProxyHeaderV2(br)
+	}
+	if bytes.HasPrefix(start, []byte("PROXY ")) {
+		return readProxyHeaderV1(br)
+	}
+	return nil, nil, ErrInvalidProxyHeader
+}
+
+// readProxyHeaderV1 reads a human-readable v1 header, e.g. `PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n`.
+func readProxyHeaderV1(br *bufio.Reader) (net.Addr, net.Addr, error) {
+	// The header is at most 107 bytes long
+	var line []byte
+	for len(line) < 107 && !bytes.HasSuffix(line, []byte("\r\n")) {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	source, err := proxyTCPAddr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dest, err := proxyTCPAddr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return source, dest, nil
+}
+
+// proxyTCPAddr parses an address of a v1 header.
+func proxyTCPAddr(ip, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	p, err := strconv.ParseUint(port, 10, 16)
+	if addr == nil || err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+// readProxyHeaderV2 reads a binary v2 header.
+func readProxyHeaderV2(br *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, nil, err
+	}
+	switch header[12] & 0xf {
+	case 0:
+		// LOCAL, e.g. health checks of the load balancer
+		return nil, nil, nil
+	case 1:
+		// PROXY
+	default:
+		return nil, nil, ErrInvalidProxyHeader
+	}
+
+	var size int
+	switch header[13] >> 4 {
+	case 1:
+		size = net.IPv4len
+	case 2:
+		size = net.IPv6len
+	default:
+		// Unix sockets and unspecified families carry no usable addresses
+		return nil, nil, nil
+	}
+	if len(payload) < 2*size+4 {
+		return nil, nil, ErrInvalidProxyHeader
+	}
+	sourceIP, destIP := net.IP(payload[:size]), net.IP(payload[size:2*size])
+	sourcePort := int(binary.BigEndian.Uint16(payload[2*size:]))
+	destPort := int(binary.BigEndian.Uint16(payload[2*size+2:]))
+	if header[13]&0xf == 2 {
+		return &net.UDPAddr{IP: sourceIP, Port: sourcePort}, &net.UDPAddr{IP: destIP, Port: destPort}, nil
+	}
+	return &net.TCPAddr{IP: sourceIP, Port: sourcePort}, &net.TCPAddr{IP: destIP, Port: destPort}, nil
+}
+
+// WriteProxyHeader writes a PROXY protocol header of the given version (1 or 2) announcing a connection from
+// source to dest. Addresses other than TCP addresses of the same IP family are announced as unknown.
+func WriteProxyHeader(w io.Writer, version int, source, dest net.Addr) error {
+	src, srcOk := source.(*net.TCPAddr)
+	dst, dstOk := dest.(*net.TCPAddr)
+	known := srcOk && dstOk && (src.IP.To4() == nil) == (dst.IP.To4() == nil)
+	switch version {
+	case 1:
+		header := "PROXY UNKNOWN\r\n"
+		if known {
+			family := "TCP6"
+			if src.IP.To4() != nil {
+				family = "TCP4"
+			}
+			header = fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, src.IP, dst.IP, src.Port, dst.Port)
+		}
+		_, err := io.WriteString(w, header)
+		return err
+	case 2:
+		header := append([]byte(nil), proxyV2Signature...)
+		header = append(header, 0x21)
+		if !known {
+			header = append(header, 0x00, 0, 0)
+		} else {
+			srcIP, dstIP, family := src.IP.To4(), dst.IP.To4(), byte(0x11)
+			if srcIP == nil {
+				srcIP, dstIP, family = src.IP.To16(), dst.IP.To16(), 0x21
+			}
+			header = append(header, family)
+			header = binary.BigEndian.AppendUint16(header, uint16(2*len(srcIP)+4))
+			header = append(header, srcIP...)
+			header = append(header, dstIP...)
+			header = binary.BigEndian.AppendUint16(header, uint16(src.Port))
+			header = binary.BigEndian.AppendUint16(header, uint16(dst.Port))
+		}
+		_, err := w.Write(header)
+		return err
+	default:
+		return fmt.Errorf("Unsupported PROXY protocol version %d", version)
+	}
+}
@@ -0,0 +1,110 @@
+package support
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+)
+
+// ptyEOT is sent to the terminal when the input stream ends, like pressing Ctrl-D.
+const ptyEOT = 0x04
+
+// PTYHandler returns an http.Handler that runs the command created by newCmd for every request attached to a newly
+// allocated PTY and pipes the PTY to/from the hijacked connection, enabling interactive shells with job control.
+// On windows, the command is attached to a pseudo console (ConPTY), so cmd and PowerShell get terminal semantics.
+// The output is sent raw (clients must not use DockerTermProtocol). If newCmd fails, the request is answered with
+// 400 Bad Request. The process is killed when the connection drops or Shutdown is called.
+func PTYHandler(newCmd CommandFunc) http.Handler {
+	return ptyHandler(newCmd, nil)
+}
+
+// ptyHandler returns the handler of PTYHandler, registering the sessions with the given manager if set.
+func ptyHandler(newCmd CommandFunc, sessions *PTYSessionManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		cmd, err := newCmd(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var id string
+		if sessions != nil {
+			if id, err = sessions.reserve(req); err == ErrPTYSessionExists {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer sessions.remove(id)
+			w.Header().Set(SessionHeader, id)
+		}
+		in, out, err := HijackServerWithOptions(w, HijackServerOptions{StatusCode: responseStatusCode(req), Request: req})
+		if err != nil {
+			hijackError(w, err)
+			return
+		}
+		defer in.Close()
+		defer CloseStreams(in, out)
+
+		// The request context is cancelled by net/http once the hijacked input stream ends
+		ctx, cancel := DefaultConnRegistry.notify(context.WithoutCancel(req.Context()))
+		defer cancel()
+		started := func(ptmx ptyDevice) {
+			if sessions != nil {
+				sessions.set(id, ptmx)
+			}
+		}
+		if err := runPTY(ctx, cmd, in, out, started); err != nil {
+			fmt.Fprintf(out, "%s\r\n", err)
+		}
+	})
+}
+
+// ptyDevice is a command started attached to a new PTY by startPTY. Reads and writes go to the terminal.
+type ptyDevice interface {
+	io.ReadWriteCloser
+	Resize(width, height uint) error
+	Kill() error
+	Wait() error // Waits for the command to exit like exec.Cmd.Wait
+}
+
+// runPTY runs the given command attached to a new PTY connected to the given streams until it exits, killing it
+// when the context is done or the output stream fails. started is called with the PTY once the command has been started.
+// A non-zero exit code is returned as *ExitError.
+func runPTY(ctx context.Context, cmd *exec.Cmd, in io.Reader, out io.Writer, started func(ptmx ptyDevice)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ptmx, err := startPTY(cmd)
+	if err != nil {
+		return err
+	}
+	defer ptmx.Close()
+	started(ptmx)
+
+	go func() {
+		if _, err := io.Copy(ptmx, in); err != nil {
+			// The connection dropped
+			cancel()
+			return
+		}
+		ptmx.Write([]byte{ptyEOT})
+	}()
+	go func() {
+		<-ctx.Done()
+		ptmx.Kill()
+	}()
+
+	// Reading fails once the process has exited and all output has been read
+	io.Copy(&cancelWriter{out, cancel}, ptmx)
+	err = ptmx.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		status := processExitStatus(exitErr.ProcessState)
+		return &ExitError{Code: status.ExitCode, Signal: status.Signal}
+	}
+	return err
+}
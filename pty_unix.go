@@ -0,0 +1,37 @@
+//go:build !windows
+
+package support
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/creack/pty"
+)
+
+// unixPTY is a command attached to a PTY.
+type unixPTY struct {
+	*os.File // The PTY master
+	cmd      *exec.Cmd
+}
+
+// startPTY starts the given command attached to a new PTY.
+func startPTY(cmd *exec.Cmd) (ptyDevice, error) {
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return &unixPTY{File: ptmx, cmd: cmd}, nil
+}
+
+func (p *unixPTY) Resize(width, height uint) error {
+	return pty.Setsize(p.File, &pty.Winsize{Cols: uint16(width), Rows: uint16(height)})
+}
+
+func (p *unixPTY) Kill() error {
+	return p.cmd.Process.Kill()
+}
+
+func (p *unixPTY) Wait() error {
+	return p.cmd.Wait()
+}
@@ -0,0 +1,166 @@
+package support
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// conPTY is a command attached to a pseudo console (ConPTY), available since Windows 10 1809.
+type conPTY struct {
+	console windows.Handle
+	input   *os.File // Written to the console
+	output  *os.File // Read from the console
+	process *os.Process
+	exited  chan struct{}
+	state   *os.ProcessState
+	err     error
+	once    sync.Once
+}
+
+// startPTY starts the given command attached to a new pseudo console. Since windows processes cannot be started
+// with a pseudo console by exec.Cmd, the process is created directly and set as Process of the command.
+// The SysProcAttr of the command is ignored.
+func startPTY(cmd *exec.Cmd) (ptyDevice, error) {
+	var consoleIn, input, output, consoleOut windows.Handle
+	if err := windows.CreatePipe(&consoleIn, &input, nil, 0); err != nil {
+		return nil, err
+	}
+	if err := windows.CreatePipe(&output, &consoleOut, nil, 0); err != nil {
+		windows.CloseHandle(consoleIn)
+		windows.CloseHandle(input)
+		return nil, err
+	}
+	p := &conPTY{
+		input:  os.NewFile(uintptr(input), "conpty-input"),
+		output: os.NewFile(uintptr(output), "conpty-output"),
+		exited: make(chan struct{}),
+	}
+	err := windows.CreatePseudoConsole(windows.Coord{X: 80, Y: 25}, consoleIn, consoleOut, 0, &p.console)
+	// The console has its own handles of the pipes
+	windows.CloseHandle(consoleIn)
+	windows.CloseHandle(consoleOut)
+	if err != nil {
+		p.input.Close()
+		p.output.Close()
+		return nil, err
+	}
+	if err := p.start(cmd); err != nil {
+		p.Close()
+		return nil, err
+	}
+	go func() {
+		p.state, p.err = p.process.Wait()
+		// The output only ends when the console is closed
+		windows.ClosePseudoConsole(p.console)
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+// start creates the process of the given command attached to the console.
+func (p *conPTY) start(cmd *exec.Cmd) error {
+	attributes, err := windows.NewProcThreadAttributeList(1)
+	if err != nil {
+		return err
+	}
+	defer attributes.Delete()
+	// The value of the attribute is the console handle itself, not a pointer to it
+	console := *(*unsafe.Pointer)(unsafe.Pointer(&p.console))
+	if err := attributes.Update(windows.PROC_THREAD_ATTRIBUTE_PSEUDOCONSOLE, console, unsafe.Sizeof(p.console)); err != nil {
+		return err
+	}
+	startupInfo := &windows.StartupInfoEx{ProcThreadAttributeList: attributes.List()}
+	startupInfo.Cb = uint32(unsafe.Sizeof(*startupInfo))
+	// Do not pass the standard handles of the server, the console provides them
+	startupInfo.Flags = windows.STARTF_USESTDHANDLES
+
+	appName, err := windows.UTF16PtrFromString(cmd.Path)
+	if err != nil {
+		return err
+	}
+	commandLine, err := windows.UTF16PtrFromString(windows.ComposeCommandLine(cmd.Args))
+	if err != nil {
+		return err
+	}
+	var dir *uint16
+	if cmd.Dir != "" {
+		if dir, err = windows.UTF16PtrFromString(cmd.Dir); err != nil {
+			return err
+		}
+	}
+	env := cmd.Environ()
+	var info windows.ProcessInformation
+	flags := uint32(windows.EXTENDED_STARTUPINFO_PRESENT | windows.CREATE_UNICODE_ENVIRONMENT)
+	if err := windows.CreateProcess(appName, commandLine, nil, nil, false, flags, environmentBlock(env), dir, &startupInfo.StartupInfo, &info); err != nil {
+		return err
+	}
+	defer windows.CloseHandle(info.Process)
+	windows.CloseHandle(info.Thread)
+	if p.process, err = os.FindProcess(int(info.ProcessId)); err != nil {
+		return err
+	}
+	cmd.Process = p.process
+	return nil
+}
+
+// environmentBlock returns the given environment as a block of NUL terminated UTF-16 strings.
+func environmentBlock(env []string) *uint16 {
+	if len(env) == 0 {
+		return nil
+	}
+	block := utf16.Encode([]rune(strings.Join(env, "\x00") + "\x00\x00"))
+	return &block[0]
+}
+
+func (p *conPTY) Read(b []byte) (int, error) {
+	return p.output.Read(b)
+}
+
+func (p *conPTY) Write(b []byte) (int, error) {
+	return p.input.Write(b)
+}
+
+func (p *conPTY) Resize(width, height uint) error {
+	select {
+	case <-p.exited:
+		return os.ErrClosed
+	default:
+	}
+	return windows.ResizePseudoConsole(p.console, windows.Coord{X: int16(width), Y: int16(height)})
+}
+
+func (p *conPTY) Kill() error {
+	if p.process == nil {
+		return os.ErrProcessDone
+	}
+	return p.process.Kill()
+}
+
+func (p *conPTY) Wait() error {
+	<-p.exited
+	if p.err != nil {
+		return p.err
+	}
+	if !p.state.Success() {
+		return &exec.ExitError{ProcessState: p.state}
+	}
+	return nil
+}
+
+func (p *conPTY) Close() error {
+	p.once.Do(func() {
+		if p.process == nil {
+			// The process could not be started, otherwise the console is closed once it exits
+			windows.ClosePseudoConsole(p.console)
+		}
+		p.input.Close()
+		p.output.Close()
+	})
+	return nil
+}
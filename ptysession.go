@@ -0,0 +1,114 @@
+package support
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// SessionHeader is the header containing the id of a session, sent by clients that choose the id themselves (or
+// attach to an existing session) and in the responses of the handlers of a PTYSessionManager or SessionManager.
+const SessionHeader = "X-Hijack-Session-Id"
+
+var (
+	ErrPTYSessionNotFound = errors.New("PTY session not found")
+	ErrPTYSessionExists   = errors.New("PTY session already exists")
+	ErrInvalidSize        = errors.New("Invalid terminal size")
+)
+
+// PTYSessionManager keeps track of the PTY sessions of its Handler, so a separate resize endpoint (see ResizeHandler)
+// can apply size changes of the client terminal to them.
+type PTYSessionManager struct {
+	mutex    sync.Mutex
+	sessions map[string]ptyDevice // nil while the command is starting
+}
+
+// NewPTYSessionManager creates a manager without sessions.
+func NewPTYSessionManager() *PTYSessionManager {
+	return &PTYSessionManager{sessions: make(map[string]ptyDevice)}
+}
+
+// Handler returns a PTYHandler whose sessions are tracked by the manager. The id of a session is taken from the
+// SessionHeader or the `session` query parameter of the request, or generated if neither is set, and returned
+// in the SessionHeader of the response.
+func (m *PTYSessionManager) Handler(newCmd CommandFunc) http.Handler {
+	return ptyHandler(newCmd, m)
+}
+
+// ResizeHandler returns an http.Handler resizing the PTY of the session given by the `session` query parameter
+// (or SessionHeader) to the `w` and `h` query parameters, as sent by HTTPResize.
+func (m *PTYSessionManager) ResizeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		query := req.URL.Query()
+		width, werr := strconv.ParseUint(query.Get("w"), 10, 16)
+		height, herr := strconv.ParseUint(query.Get("h"), 10, 16)
+		if werr != nil || herr != nil {
+			http.Error(w, ErrInvalidSize.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := m.Resize(sessionID(req), uint(width), uint(height)); err == ErrPTYSessionNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// Resize resizes the PTY of the session with the given id.
+func (m *PTYSessionManager) Resize(id string, width, height uint) error {
+	m.mutex.Lock()
+	ptmx := m.sessions[id]
+	m.mutex.Unlock()
+	if ptmx == nil {
+		return ErrPTYSessionNotFound
+	}
+	return ptmx.Resize(width, height)
+}
+
+// reserve reserves the id of the session of the given request.
+func (m *PTYSessionManager) reserve(req *http.Request) (string, error) {
+	id := sessionID(req)
+	if id == "" {
+		var err error
+		if id, err = newSessionID(); err != nil {
+			return "", err
+		}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.sessions[id]; ok {
+		return "", ErrPTYSessionExists
+	}
+	m.sessions[id] = nil
+	return id, nil
+}
+
+// set sets the PTY of the session with the given id once its command has been started.
+func (m *PTYSessionManager) set(id string, ptmx ptyDevice) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sessions[id] = ptmx
+}
+
+// remove removes the session with the given id.
+func (m *PTYSessionManager) remove(id string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.sessions, id)
+}
+
+// sessionID returns the session id sent with the given request.
+func sessionID(req *http.Request) string {
+	if id := req.Header.Get(SessionHeader); id != "" {
+		return id
+	}
+	return req.URL.Query().Get("session")
+}
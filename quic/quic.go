@@ -0,0 +1,186 @@
+// Package quic provides an experimental transport that runs hijacked streams over a QUIC bidirectional stream.
+//
+// This is not HTTP/3: each connection carries a single QUIC stream on which the regular HTTP/1.1 upgrade request of
+// this package is sent and the stream is hijacked afterwards, just like a TCP connection. The connection negotiates
+// the private ALPN protocol NextProto, so both ends have to use this package: clients dial `https3://` URLs with Dial
+// and servers accept them with Listen.
+//
+// Importing this package registers the transport for `https3://` URLs:
+//
+//	import _ "github.com/giantswarm/hijack-stream-support/quic"
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	neturl "net/url"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	support "github.com/giantswarm/hijack-stream-support"
+)
+
+// Scheme is the URL scheme for which this transport is registered.
+const Scheme = "https3"
+
+// NextProto is the ALPN protocol negotiated for hijacked streams over QUIC.
+const NextProto = "hijack-stream"
+
+const (
+	keepAlivePeriod = 15 * time.Second
+	// acceptStreamTimeout is how long Listener waits for the stream of an accepted connection.
+	acceptStreamTimeout = 10 * time.Second
+)
+
+func init() {
+	support.RegisterDefaultPort(Scheme, 443)
+	support.RegisterTransport(Scheme, Dial)
+}
+
+// Dial establishes a QUIC connection to the host of the given URL and opens a bidirectional stream on it.
+// The returned connection can be used as any other connection of this package.
+func Dial(ep *neturl.URL) (net.Conn, error) {
+	address, err := support.DialAddress(ep)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		ServerName: ep.Hostname(),
+		NextProtos: []string{NextProto},
+	}
+	ctx := context.Background()
+	conn, err := quic.DialAddr(ctx, address, config, &quic.Config{KeepAlivePeriod: keepAlivePeriod})
+	if err != nil {
+		return nil, err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+	return &streamConn{Stream: stream, conn: conn}, nil
+}
+
+// Listener accepts the streams of connections established by Dial. It implements net.Listener, so it can be served
+// with net/http and a handler using support.HijackHandler or support.HijackServer:
+//
+//	listener, err := quic.Listen(":443", tlsConfig)
+//	...
+//	http.Serve(listener, support.HijackHandler(handler))
+type Listener struct {
+	listener  *quic.Listener
+	ctx       context.Context
+	cancel    context.CancelFunc
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// Listen listens for QUIC connections on the given UDP address. The TLS config must contain the server certificate,
+// its NextProtos are replaced by NextProto.
+func Listen(address string, config *tls.Config) (*Listener, error) {
+	if config == nil {
+		return nil, errors.New("A TLS config is required")
+	}
+	config = config.Clone()
+	config.NextProtos = []string{NextProto}
+	listener, err := quic.ListenAddr(address, config, &quic.Config{KeepAlivePeriod: keepAlivePeriod})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Listener{
+		listener: listener,
+		ctx:      ctx,
+		cancel:   cancel,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	go l.serve()
+	return l, nil
+}
+
+// serve accepts connections until the listener fails or is closed.
+func (l *Listener) serve() {
+	for {
+		conn, err := l.listener.Accept(l.ctx)
+		if err != nil {
+			l.fail(err)
+			return
+		}
+		go l.acceptStream(conn)
+	}
+}
+
+// acceptStream waits for the stream opened by Dial on the given connection and hands it to Accept.
+func (l *Listener) acceptStream(conn *quic.Conn) {
+	ctx, cancel := context.WithTimeout(l.ctx, acceptStreamTimeout)
+	defer cancel()
+	stream, err := conn.AcceptStream(ctx)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return
+	}
+	c := &streamConn{Stream: stream, conn: conn}
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+// fail stops accepting connections, Accept returns the given error afterwards.
+func (l *Listener) fail(err error) {
+	l.closeOnce.Do(func() {
+		l.err = err
+		l.cancel()
+		close(l.done)
+	})
+}
+
+// Accept waits for and returns the stream of the next connection.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// Close stops listening. Already accepted connections are not closed.
+func (l *Listener) Close() error {
+	l.fail(net.ErrClosed)
+	return l.listener.Close()
+}
+
+// Addr returns the UDP address the listener is listening on.
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// streamConn adapts a QUIC stream to the net.Conn interface.
+type streamConn struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+// CloseWrite closes the send direction of the stream.
+func (c *streamConn) CloseWrite() error {
+	return c.Stream.Close()
+}
+
+// Close closes both directions of the stream and the underlying QUIC connection.
+func (c *streamConn) Close() error {
+	c.Stream.CancelRead(0)
+	c.Stream.Close()
+	return c.conn.CloseWithError(0, "")
+}
+
+func (c *streamConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *streamConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
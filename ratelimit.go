@@ -0,0 +1,89 @@
+package support
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// tokenBucket limits a rate in bytes per second, allowing bursts of up to one second.
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   int
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: float64(rate), last: time.Now()}
+}
+
+// burst returns the maximum number of bytes that should be transferred at once.
+func (b *tokenBucket) burst() int {
+	return b.rate
+}
+
+// wait takes n tokens, sleeping until the bucket has been refilled if it runs into debt.
+func (b *tokenBucket) wait(n int) {
+	b.mutex.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * float64(b.rate)
+	if b.tokens > float64(b.rate) {
+		b.tokens = float64(b.rate)
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	debt := b.tokens
+	b.mutex.Unlock()
+
+	if debt < 0 {
+		time.Sleep(time.Duration(-debt / float64(b.rate) * float64(time.Second)))
+	}
+}
+
+// rateLimitedConn limits the bandwidth of a connection per direction.
+type rateLimitedConn struct {
+	net.Conn
+	read  *tokenBucket // nil if reads are not limited
+	write *tokenBucket // nil if writes are not limited
+}
+
+func (c *rateLimitedConn) Read(p []byte) (int, error) {
+	if c.read == nil {
+		return c.Conn.Read(p)
+	}
+	if len(p) > c.read.burst() {
+		p = p[:c.read.burst()]
+	}
+	n, err := c.Conn.Read(p)
+	c.read.wait(n)
+	return n, err
+}
+
+func (c *rateLimitedConn) Write(p []byte) (int, error) {
+	if c.write == nil {
+		return c.Conn.Write(p)
+	}
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > c.write.burst() {
+			n = c.write.burst()
+		}
+		c.write.wait(n)
+		n, err := c.Conn.Write(p[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (c *rateLimitedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package support
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// Default terminal size of recordings if the size of the RawTerminal of the options is unknown.
+const (
+	DefaultRecordWidth  = 80
+	DefaultRecordHeight = 24
+)
+
+// Recorder records the streams of a session (see HijackHttpOptions.Recorder), e.g. to ship recordings to object
+// storage or a compliance system. Its methods are called from the goroutines streaming the session, errors abort
+// streaming. Close is called once streaming has finished.
+type Recorder interface {
+	WriteInput(p []byte) error  // Records data of the input stream
+	WriteOutput(p []byte) error // Records data of the output and error streams
+	Close() error
+}
+
+// castHeader is the header line of an asciinema v2 cast file.
+type castHeader struct {
+	Version   int   `json:"version"`
+	Width     uint  `json:"width"`
+	Height    uint  `json:"height"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// CastRecorder is a Recorder writing the streams with their timing as asciinema v2 cast file. Resizes of the terminal
+// (see Session.Resize) are recorded as well.
+type CastRecorder struct {
+	mutex   sync.Mutex
+	w       io.Writer
+	closer  io.Closer // Closed by Close, if set
+	started time.Time
+	pending map[string][]byte // Incomplete UTF-8 sequence at the end of the last write by event code
+}
+
+// NewCastRecorder writes the header of a cast file with the given terminal size to the given writer and returns a
+// recorder writing to it. The writer is not closed by the recorder.
+func NewCastRecorder(w io.Writer, width, height uint) (*CastRecorder, error) {
+	c := &CastRecorder{w: w, started: time.Now(), pending: map[string][]byte{}}
+	header, err := json.Marshal(castHeader{Version: 2, Width: width, Height: height, Timestamp: c.started.Unix()})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(header, '\n')); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewFileRecorder creates a cast file at the given path with the given terminal size and returns a recorder writing
+// to it, the file is closed when the recorder is closed.
+func NewFileRecorder(path string, width, height uint) (*CastRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCastRecorder(f, width, height)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.closer = f
+	return c, nil
+}
+
+// WriteInput records the given input as input event.
+func (c *CastRecorder) WriteInput(p []byte) error {
+	return c.write("i", p)
+}
+
+// WriteOutput records the given output as output event.
+func (c *CastRecorder) WriteOutput(p []byte) error {
+	return c.write("o", p)
+}
+
+// Resize records a resize of the terminal.
+func (c *CastRecorder) Resize(width, height uint) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.event("r", fmt.Sprintf("%dx%d", width, height))
+}
+
+// Close closes the file of the recorder, if any.
+func (c *CastRecorder) Close() error {
+	if c.closer != nil {
+		return c.closer.Close()
+	}
+	return nil
+}
+
+// write records the given data as event with the given code.
+func (c *CastRecorder) write(code string, p []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	data := append(c.pending[code], p...)
+	// Events must be valid UTF-8, so a multi-byte character split between writes is recorded once complete
+	end := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				end = i
+			}
+			break
+		}
+	}
+	c.pending[code] = append([]byte(nil), data[end:]...)
+	if end == 0 {
+		return nil
+	}
+	return c.event(code, string(data[:end]))
+}
+
+// event writes an event with the given code and data, the mutex must be locked.
+func (c *CastRecorder) event(code, data string) error {
+	line, err := json.Marshal([]interface{}{time.Since(c.started).Seconds(), code, data})
+	if err != nil {
+		return err
+	}
+	_, err = c.w.Write(append(line, '\n'))
+	return err
+}
+
+// startRecording returns the recorder of the session, the Recorder of the options or else a CastRecorder writing to
+// their RecordWriter, nil if it is not recorded.
+func (s *Session) startRecording() (Recorder, error) {
+	options := s.options
+	recorder := options.Recorder
+	if recorder == nil && options.RecordWriter != nil {
+		width, height := recordSize(options)
+		cast, err := NewCastRecorder(options.RecordWriter, width, height)
+		if err != nil {
+			return nil, err
+		}
+		recorder = cast
+	}
+	if recorder != nil && options.Redact != nil {
+		recorder = newRedactingRecorder(recorder, options.Redact)
+	}
+	// Resizes are recorded through the same recorder, so they are ordered with the (buffered) data
+	s.mutex.Lock()
+	s.recorder = recorder
+	s.mutex.Unlock()
+	return recorder, nil
+}
+
+// resizeRecorder is a Recorder that records resizes of the terminal, e.g. CastRecorder.
+type resizeRecorder interface {
+	Resize(width, height uint) error
+}
+
+// recordSize returns the terminal size of recordings of the given options.
+func recordSize(options HijackHttpOptions) (uint, uint) {
+	if options.RawTerminal != nil {
+		if width, height, err := term.GetSize(int(options.RawTerminal.Fd())); err == nil && width > 0 && height > 0 {
+			return uint(width), uint(height)
+		}
+	}
+	return DefaultRecordWidth, DefaultRecordHeight
+}
+
+// recordWriter passes the data written to it to a function of a Recorder.
+type recordWriter func(p []byte) error
+
+func (w recordWriter) Write(p []byte) (int, error) {
+	if err := w(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
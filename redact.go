@@ -0,0 +1,181 @@
+package support
+
+import (
+	"bytes"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRedactLine is the maximum length of a line that is buffered for redaction, longer lines are redacted in chunks.
+	maxRedactLine = 4096
+	// redactFlushDelay is the time an incomplete line (e.g. a prompt) is buffered for redaction before it is passed on.
+	redactFlushDelay = 500 * time.Millisecond
+)
+
+// Redactor returns the given data with secrets redacted (see HijackHttpOptions.Redact). It is called with complete
+// lines where possible, so secrets are not split between calls.
+type Redactor func(p []byte) []byte
+
+// DefaultRedactPatterns match common secrets: values of password, secret, token and API key assignments, bearer
+// tokens, AWS access key IDs and JWTs.
+var DefaultRedactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(?:password|passwd|secret|token|api[_-]?key)\s*[:=]\s*["']?([^\s"']+)`),
+	regexp.MustCompile(`(?i)bearer\s+([A-Za-z0-9\-._~+/]+=*)`),
+	regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`),
+	regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`),
+}
+
+// redacted replaces redacted secrets.
+var redacted = []byte("[REDACTED]")
+
+// RedactPatterns returns a Redactor replacing the matches of the given patterns (DefaultRedactPatterns if none are
+// given) with [REDACTED]. If a pattern has a capturing group, only the text of its first group is replaced.
+func RedactPatterns(patterns ...*regexp.Regexp) Redactor {
+	if len(patterns) == 0 {
+		patterns = DefaultRedactPatterns
+	}
+	return func(p []byte) []byte {
+		for _, pattern := range patterns {
+			matches := pattern.FindAllSubmatchIndex(p, -1)
+			if len(matches) == 0 {
+				continue
+			}
+			var b bytes.Buffer
+			last := 0
+			for _, match := range matches {
+				start, end := match[0], match[1]
+				if len(match) > 2 && match[2] >= 0 {
+					start, end = match[2], match[3]
+				}
+				b.Write(p[last:start])
+				b.Write(redacted)
+				last = end
+			}
+			b.Write(p[last:])
+			p = b.Bytes()
+		}
+		return p
+	}
+}
+
+// redactingWriter buffers the data written to it and passes it line by line with secrets redacted to a function.
+// Lines end with \n or \r (as written by terminals), incomplete lines are passed on after redactFlushDelay.
+type redactingWriter struct {
+	mutex  sync.Mutex
+	write  func(p []byte) error
+	redact Redactor
+	buf    []byte
+	timer  *time.Timer // Flushes the incomplete line, set while one is buffered
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.buf = append(w.buf, p...)
+	end := bytes.LastIndexAny(w.buf, "\r\n") + 1
+	if len(w.buf)-end > maxRedactLine {
+		end = len(w.buf)
+	}
+	var err error
+	if end > 0 {
+		data := w.buf[:end]
+		w.buf = append([]byte(nil), w.buf[end:]...)
+		err = w.write(w.redact(data))
+	}
+	w.scheduleFlush()
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// scheduleFlush starts the timer passing on the buffered incomplete line, or stops it if there is none.
+// The mutex must be held.
+func (w *redactingWriter) scheduleFlush() {
+	if len(w.buf) == 0 {
+		if w.timer != nil {
+			w.timer.Stop()
+			w.timer = nil
+		}
+		return
+	}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(redactFlushDelay, func() { w.Flush() })
+	}
+}
+
+// Flush passes the buffered incomplete line on.
+func (w *redactingWriter) Flush() error {
+	return w.flushThen(nil)
+}
+
+// flushThen passes the buffered incomplete line on and calls fn (if set) before further data is passed on.
+func (w *redactingWriter) flushThen(fn func() error) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var err error
+	if len(w.buf) > 0 {
+		data := w.buf
+		w.buf = nil
+		err = w.write(w.redact(data))
+	}
+	w.scheduleFlush()
+	if fn != nil {
+		if ferr := fn(); err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+// redactingRecorder is a Recorder recording data with secrets redacted.
+type redactingRecorder struct {
+	Recorder
+	input  *redactingWriter
+	output *redactingWriter
+}
+
+// newRedactingRecorder returns a recorder recording to the given recorder with the given redactor.
+func newRedactingRecorder(recorder Recorder, redact Redactor) Recorder {
+	return &redactingRecorder{
+		Recorder: recorder,
+		input:    &redactingWriter{write: recorder.WriteInput, redact: redact},
+		output:   &redactingWriter{write: recorder.WriteOutput, redact: redact},
+	}
+}
+
+func (r *redactingRecorder) WriteInput(p []byte) error {
+	_, err := r.input.Write(p)
+	return err
+}
+
+func (r *redactingRecorder) WriteOutput(p []byte) error {
+	_, err := r.output.Write(p)
+	return err
+}
+
+// Resize records a resize of the terminal if the recorder supports it (e.g. CastRecorder), after the buffered data,
+// so it is recorded in order.
+func (r *redactingRecorder) Resize(width, height uint) error {
+	resizer, ok := r.Recorder.(resizeRecorder)
+	if !ok {
+		return nil
+	}
+	if err := r.input.Flush(); err != nil {
+		return err
+	}
+	return r.output.flushThen(func() error { return resizer.Resize(width, height) })
+}
+
+func (r *redactingRecorder) Close() error {
+	err := r.input.Flush()
+	if oerr := r.output.Flush(); err == nil {
+		err = oerr
+	}
+	if cerr := r.Recorder.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,264 @@
+package support
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrServerShutdown     = errors.New("Server is shutting down")
+	ErrTooManyConnections = errors.New("Too many hijacked connections")
+)
+
+// shutdownPollInterval is the interval in which Shutdown checks whether all connections have been closed.
+const shutdownPollInterval = 50 * time.Millisecond
+
+// ConnRegistry tracks the connections hijacked by the server helpers, since http.Server.Shutdown does not know
+// about them. Use Shutdown to end them gracefully.
+type ConnRegistry struct {
+	MaxConns     int          // If set, at most this many connections are hijacked at once, further hijacks fail with ErrTooManyConnections.
+	AccessLogger AccessLogger // If set, the metadata of every tracked connection is recorded when it is closed.
+
+	mutex    sync.Mutex
+	conns    map[io.Closer]struct{}
+	pending  int // Connections reserved but not yet tracked
+	shutdown chan struct{}
+	closed   bool
+}
+
+// DefaultConnRegistry is the registry used by the server helpers unless HijackServerOptions.Registry is set.
+var DefaultConnRegistry = NewConnRegistry()
+
+// NewConnRegistry creates an empty registry.
+func NewConnRegistry() *ConnRegistry {
+	return &ConnRegistry{
+		conns:    make(map[io.Closer]struct{}),
+		shutdown: make(chan struct{}),
+	}
+}
+
+// Shutdown shuts down the connections of DefaultConnRegistry, see ConnRegistry.Shutdown.
+func Shutdown(ctx context.Context) error {
+	return DefaultConnRegistry.Shutdown(ctx)
+}
+
+// Shutdown stops accepting new hijacks (ErrServerShutdown is returned instead), notifies active sessions by closing
+// the channel returned by ShuttingDown (which cancels the context of a StreamHandlerFunc) and waits for all connections
+// to be closed. Connections still open when the given context is done are closed and the error of the context is returned.
+func (r *ConnRegistry) Shutdown(ctx context.Context) error {
+	r.mutex.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.shutdown)
+	}
+	r.mutex.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if r.Len() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			r.mutex.Lock()
+			conns := r.conns
+			r.conns = make(map[io.Closer]struct{})
+			r.mutex.Unlock()
+			for conn := range conns {
+				if tracked, ok := conn.(*trackedConn); ok {
+					tracked.setCloseReason(CloseReasonShutdown)
+				}
+				conn.Close()
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ShuttingDown returns a channel that is closed when Shutdown has been called.
+func (r *ConnRegistry) ShuttingDown() <-chan struct{} {
+	return r.shutdown
+}
+
+// Len returns the number of tracked connections, including connections that are being hijacked.
+func (r *ConnRegistry) Len() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.conns) + r.pending
+}
+
+// isShuttingDown returns true if Shutdown has been called.
+func (r *ConnRegistry) isShuttingDown() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.closed
+}
+
+// reserve reserves a connection before hijacking, so errors can still be sent as response.
+// It fails with ErrServerShutdown once Shutdown has been called and with ErrTooManyConnections if MaxConns is reached.
+// The reservation must be ended using add or release.
+func (r *ConnRegistry) reserve() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return ErrServerShutdown
+	}
+	if r.MaxConns > 0 && len(r.conns)+r.pending >= r.MaxConns {
+		return ErrTooManyConnections
+	}
+	r.pending++
+	return nil
+}
+
+// release ends a reservation without tracking a connection.
+func (r *ConnRegistry) release() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.pending--
+}
+
+// add tracks the given connection for a reservation.
+func (r *ConnRegistry) add(conn io.Closer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.pending--
+	r.conns[conn] = struct{}{}
+}
+
+// remove stops tracking the given connection.
+func (r *ConnRegistry) remove(conn io.Closer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.conns, conn)
+}
+
+// track tracks the given connection of the given request (which may be nil) for a reservation until it is closed.
+func (r *ConnRegistry) track(conn net.Conn, req *http.Request) *trackedConn {
+	tracked := &trackedConn{Conn: conn, registry: r, req: req, start: time.Now()}
+	r.add(tracked)
+	return tracked
+}
+
+// notify returns a context that is cancelled when Shutdown is called.
+func (r *ConnRegistry) notify(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-r.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// trackedConn is a connection that is removed from its registry when closed.
+// It counts the transferred bytes for the AccessLogger of the registry.
+type trackedConn struct {
+	net.Conn
+	registry *ConnRegistry
+	req      *http.Request
+	start    time.Time
+	bytesIn  int64
+	bytesOut int64
+
+	mutex       sync.Mutex
+	closeReason string
+	closed      bool
+	readDone    bool // Set once a read has failed, e.g. at the end of the input stream
+	writeClosed bool // Set once the connection has been closed for writing
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.bytesIn, int64(n))
+	if err != nil {
+		if err != io.EOF {
+			c.setCloseReason(err.Error())
+		}
+		c.mutex.Lock()
+		c.readDone = true
+		writeClosed := c.writeClosed
+		c.mutex.Unlock()
+		if writeClosed {
+			// Both directions have ended
+			c.Close()
+		}
+	}
+	return n, err
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.bytesOut, int64(n))
+	if err != nil {
+		c.setCloseReason(err.Error())
+	}
+	return n, err
+}
+
+// setCloseReason sets the reason the connection is closed for, unless already set.
+func (c *trackedConn) setCloseReason(reason string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closeReason == "" {
+		c.closeReason = reason
+	}
+}
+
+// CloseWrite closes the connection for writing. Once the input stream has ended as well, the connection is closed,
+// so it is removed from its registry. Until then, it is only closed by Close or at the deadline of Shutdown.
+func (c *trackedConn) CloseWrite() error {
+	var err error
+	if cw, ok := c.Conn.(closeWriter); ok {
+		err = cw.CloseWrite()
+	}
+	c.mutex.Lock()
+	c.writeClosed = true
+	readDone := c.readDone
+	c.mutex.Unlock()
+	if readDone || err != nil {
+		c.Close()
+	}
+	return err
+}
+
+func (c *trackedConn) Close() error {
+	c.registry.remove(c)
+	err := c.Conn.Close()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed || c.registry.AccessLogger == nil {
+		c.closed = true
+		return err
+	}
+	c.closed = true
+	entry := AccessLogEntry{
+		Start:       c.start,
+		Duration:    time.Since(c.start),
+		BytesIn:     atomic.LoadInt64(&c.bytesIn),
+		BytesOut:    atomic.LoadInt64(&c.bytesOut),
+		CloseReason: c.closeReason,
+	}
+	if c.req != nil {
+		entry.URL = c.req.URL.String()
+		entry.RequestID = c.req.Header.Get(RequestIDHeader)
+		entry.RemoteAddr = c.req.RemoteAddr
+	} else if addr := c.RemoteAddr(); addr != nil {
+		entry.RemoteAddr = addr.String()
+	}
+	if entry.CloseReason == "" {
+		entry.CloseReason = CloseReasonClosed
+	}
+	c.registry.AccessLogger.LogSession(entry)
+	return err
+}
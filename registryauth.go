@@ -0,0 +1,39 @@
+package support
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+)
+
+// RegistryAuthHeader is the header used by the docker API to pass registry credentials.
+const RegistryAuthHeader = "X-Registry-Auth"
+
+// AuthConfig holds the credentials for a docker registry.
+// Either Username and Password or IdentityToken should be set.
+type AuthConfig struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+	ServerAddress string `json:"serveraddress,omitempty"`
+}
+
+// EncodeRegistryAuth returns the base64 encoded value of the X-Registry-Auth header for the given credentials.
+func EncodeRegistryAuth(auth AuthConfig) (string, error) {
+	buf, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(buf), nil
+}
+
+// SetRegistryAuth sets the X-Registry-Auth header for the given credentials on the given header
+// (e.g. the Header of HijackHttpOptions).
+func SetRegistryAuth(header http.Header, auth AuthConfig) error {
+	value, err := EncodeRegistryAuth(auth)
+	if err != nil {
+		return err
+	}
+	header.Set(RegistryAuthHeader, value)
+	return nil
+}
@@ -0,0 +1,147 @@
+package support
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrInvalidRecording = errors.New("Invalid recording")
+
+// Replay writes the output of a recorded session read from r to w with its original timing, e.g. for debugging or
+// demos without a live backend. Recordings are either asciinema v2 cast files (see HijackHttpOptions.RecordWriter),
+// of which the output events are replayed, or dumps of a TraceWriter, of which the data read is replayed.
+// The timing is sped up by the given factor, a factor of 0 replays without delays. Replay stops once the context is
+// done.
+func Replay(ctx context.Context, w io.Writer, r io.Reader, speed float64) error {
+	br := bufio.NewReader(r)
+	start, err := br.Peek(1)
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	replayer := &replayer{ctx: ctx, w: w, speed: speed, started: time.Now()}
+	if start[0] == '{' {
+		return replayer.replayCast(br)
+	}
+	return replayer.replayDump(br)
+}
+
+// replayer writes recorded output with its timing.
+type replayer struct {
+	ctx     context.Context
+	w       io.Writer
+	speed   float64
+	started time.Time
+}
+
+// write writes the given data once the given time since the start of the recording has passed.
+func (r *replayer) write(at time.Duration, data []byte) error {
+	if r.speed > 0 {
+		timer := time.NewTimer(time.Until(r.started.Add(time.Duration(float64(at) / r.speed))))
+		select {
+		case <-timer.C:
+		case <-r.ctx.Done():
+			timer.Stop()
+			return r.ctx.Err()
+		}
+	} else if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	_, err := r.w.Write(data)
+	return err
+}
+
+// replayCast replays the output events of an asciinema v2 cast file.
+func (r *replayer) replayCast(br *bufio.Reader) error {
+	line, err := br.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	var header castHeader
+	if err := json.Unmarshal(line, &header); err != nil || header.Version != 2 {
+		return fmt.Errorf("%w: no asciinema v2 header", ErrInvalidRecording)
+	}
+	for {
+		line, err := br.ReadBytes('\n')
+		if strings.TrimSpace(string(line)) != "" {
+			var event []interface{}
+			if jerr := json.Unmarshal(line, &event); jerr != nil || len(event) != 3 {
+				return fmt.Errorf("%w: invalid event %q", ErrInvalidRecording, line)
+			}
+			at, ok1 := event[0].(float64)
+			code, ok2 := event[1].(string)
+			data, ok3 := event[2].(string)
+			if !ok1 || !ok2 || !ok3 {
+				return fmt.Errorf("%w: invalid event %q", ErrInvalidRecording, line)
+			}
+			if code == "o" {
+				if err := r.write(time.Duration(at*float64(time.Second)), []byte(data)); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// replayDump replays the data read of a dump of a TraceWriter (see dumpConn).
+func (r *replayer) replayDump(br *bufio.Reader) error {
+	var first time.Time
+	var at time.Duration
+	var read bool
+	var data []byte
+	flush := func() error {
+		defer func() { data = nil }()
+		if !read || len(data) == 0 {
+			return nil
+		}
+		return r.write(at, data)
+	}
+	for {
+		line, err := br.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if fields := strings.Fields(line); len(fields) == 4 && fields[2] == "bytes" {
+			// A header like `2006-01-02T15:04:05.999999999Z07:00 5 bytes read`
+			timestamp, terr := time.Parse(time.RFC3339Nano, fields[0])
+			if terr != nil {
+				return fmt.Errorf("%w: invalid dump header %q", ErrInvalidRecording, line)
+			}
+			if ferr := flush(); ferr != nil {
+				return ferr
+			}
+			if first.IsZero() {
+				first = timestamp
+			}
+			at, read = timestamp.Sub(first), fields[3] == "read"
+		} else if line != "" {
+			// A line of hex.Dump like `00000000  68 65 6c 6c 6f  |hello|`
+			end := strings.IndexByte(line, '|')
+			if end < 0 || len(line) < 10 {
+				return fmt.Errorf("%w: invalid dump line %q", ErrInvalidRecording, line)
+			}
+			b, herr := hex.DecodeString(strings.Join(strings.Fields(line[10:end]), ""))
+			if herr != nil {
+				return fmt.Errorf("%w: invalid dump line %q", ErrInvalidRecording, line)
+			}
+			data = append(data, b...)
+		}
+		if err == io.EOF {
+			return flush()
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package support
+
+import (
+	"fmt"
+
+	docker "github.com/giantswarm/hijack-stream-support/docker"
+)
+
+// RequestIDHeader is the header carrying the request (correlation) ID of a hijack request.
+const RequestIDHeader = "X-Request-Id"
+
+// RequestError is an error of a session with a request ID, so failures can be traced across services.
+type RequestError struct {
+	RequestID string
+	Err       error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("Request %s: %v", e.RequestID, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// requestIDLogger prefixes the messages of a logger with the request ID of a session, structured loggers get it
+// as request_id attribute instead.
+type requestIDLogger struct {
+	docker.Logger
+	requestID string
+}
+
+func (l *requestIDLogger) Debugf(msg string, args ...interface{}) {
+	l.Logger.Debugf("[%s] "+msg, append([]interface{}{l.requestID}, args...)...)
+}
+
+func (l *requestIDLogger) Infof(msg string, args ...interface{}) {
+	levels(l.Logger).Infof("[%s] "+msg, append([]interface{}{l.requestID}, args...)...)
+}
+
+func (l *requestIDLogger) Warnf(msg string, args ...interface{}) {
+	levels(l.Logger).Warnf("[%s] "+msg, append([]interface{}{l.requestID}, args...)...)
+}
+
+func (l *requestIDLogger) Errorf(msg string, args ...interface{}) {
+	levels(l.Logger).Errorf("[%s] "+msg, append([]interface{}{l.requestID}, args...)...)
+}
+
+// applyRequestID sets the request ID of the given options (generating one if requested), sends it with the request
+// and adds it to the log messages.
+func applyRequestID(options *HijackHttpOptions) error {
+	if options.RequestID == "" && options.GenerateRequestID {
+		id, err := newSessionID()
+		if err != nil {
+			return err
+		}
+		options.RequestID = id
+	}
+	if options.RequestID == "" {
+		return nil
+	}
+	options.setHeader(RequestIDHeader, options.RequestID)
+	if _, ok := options.Log.(StructuredLogger); ok {
+		options.Log = withAttrs(options.Log, "request_id", options.RequestID)
+	} else {
+		options.Log = &requestIDLogger{options.Log, options.RequestID}
+	}
+	return nil
+}
+
+// RequestID returns the request ID of the session, empty if it has none.
+func (s *Session) RequestID() string {
+	return s.options.RequestID
+}
+
+// wrapError returns the given error of the session as *RequestError if the session has a request ID.
+func (s *Session) wrapError(err error) error {
+	if err == nil || s.options.RequestID == "" {
+		return err
+	}
+	return &RequestError{RequestID: s.options.RequestID, Err: err}
+}
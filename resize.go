@@ -0,0 +1,40 @@
+package support
+
+import (
+	"encoding/json"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+)
+
+// ChannelProtocolResize is a ResizeFunc that sends the new size in-band on the resize channel of the v4.channel.k8s.io protocol.
+func ChannelProtocolResize(s *Session, width, height uint) error {
+	data, err := json.Marshal(struct {
+		Width  uint
+		Height uint
+	}{width, height})
+	if err != nil {
+		return err
+	}
+	return s.sendChannel(ChannelResize, data)
+}
+
+// HTTPResize returns a ResizeFunc that posts the new size as `w` and `h` query parameters to the given URL,
+// e.g. `/containers/{id}/resize` or `/exec/{id}/resize` of the docker API.
+func HTTPResize(url string, header http.Header) ResizeFunc {
+	return func(s *Session, width, height uint) error {
+		ep, err := neturl.Parse(url)
+		if err != nil {
+			return err
+		}
+		endpoint, path := splitEndpointURL(ep)
+		client, err := newAPIClient(endpoint, header)
+		if err != nil {
+			return err
+		}
+		query := ep.Query()
+		query.Set("w", strconv.FormatUint(uint64(width), 10))
+		query.Set("h", strconv.FormatUint(uint64(height), 10))
+		return client.do("POST", path+"?"+query.Encode(), nil, nil)
+	}
+}
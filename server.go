@@ -1,26 +1,434 @@
 package support
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"reflect"
+	"sync"
+	"time"
 )
 
+var (
+	ErrHijackUnsupported = errors.New("Connection cannot be hijacked")
+	ErrQuotaExceeded     = errors.New("Byte quota exceeded")
+)
+
+// DefaultMaxDurationWarning is the time before the end of the MaxDuration of a connection at which
+// HijackServerOptions.OnMaxDuration is called by default.
+const DefaultMaxDurationWarning = time.Minute
+
+// RawStreamContentType is the content type of a raw stream sent with a 200 response, as used by docker.
+const RawStreamContentType = "application/vnd.docker.raw-stream"
+
+type HijackServerOptions struct {
+	StatusCode    int               // If set, a response with this status code is written onto the hijacked connection, see HijackServerResponse.
+	Header        http.Header       // Headers sent with the response in addition to the headers set on the response writer.
+	ReadTimeout   time.Duration     // If set, every read of the hijacked connection must complete within this duration (an idle timeout for the input stream).
+	WriteTimeout  time.Duration     // If set, every write to the hijacked connection must complete within this duration.
+	Request       *http.Request     // If set and its connection cannot be hijacked (e.g. HTTP/2), the request body is used as input stream and the flushed response as output stream instead, with all other options applied, see flushStreams.
+	Registry      *ConnRegistry     // Registry tracking the hijacked connection, defaults to DefaultConnRegistry.
+	ReadRate      int               // If set, reads of the hijacked connection are limited to this many bytes per second.
+	WriteRate     int               // If set, writes to the hijacked connection are limited to this many bytes per second.
+	IdleTimeout   time.Duration     // If set, the hijacked connection is closed when it has had no traffic in either direction for this duration.
+	OnIdle        func(w io.Writer) // If set, this function is called with the output stream right before an idle connection is closed, e.g. to write a warning frame. Its writes are serialized with the writes of the handler.
+	Auth          AuthFunc          // If set, Request is rejected with 401 or 403 unless accepted by this function, before the connection is hijacked.
+	MaxDuration   time.Duration     // If set, the hijacked connection is closed once it has been open for this duration.
+	WarnBefore    time.Duration     // With MaxDuration, OnMaxDuration is called this long before the connection is closed, defaults to DefaultMaxDurationWarning.
+	OnMaxDuration func(w io.Writer) // If set, this function is called with the output stream before the connection is closed for its MaxDuration (see WarnBefore), e.g. to write a warning frame. Its writes are serialized with the writes of the handler.
+	MaxBytesIn    int64             // If set, the connection is closed once more than this many bytes have been read from the client.
+	MaxBytesOut   int64             // If set, the connection is closed once a write would exceed this many bytes in total. If the client asked for an exit frame (see Request) without TTY, the close reason is sent in it (see WriteCloseReason).
+}
+
+// HijackServer hijacks the connection of the given response writer and returns its input and output streams.
+// Both streams are the same net.Conn, whose reads are served from the buffer of the hijacked connection first,
+// so data the client sent right after its request is not lost.
+// Deadlines set by the http.Server (e.g. its ReadTimeout) are cleared, so long-lived sessions are not interrupted.
+// The connection is tracked by DefaultConnRegistry until it is closed, after Shutdown ErrServerShutdown is returned
+// and beyond its MaxConns ErrTooManyConnections.
+// If the connection cannot be hijacked (e.g. HTTP/2 or a response writer wrapped by middleware without Unwrap support),
+// an error response is written and ErrHijackUnsupported is returned.
 func HijackServer(w http.ResponseWriter) (io.ReadCloser, io.Writer, error) {
-	conn, _, err := w.(http.Hijacker).Hijack()
+	return hijackServer(w, nil, DefaultConnRegistry)
+}
+
+// hijackServer hijacks the connection like HijackServer, tracking it together with the given request
+// (which may be nil) in the given registry.
+func hijackServer(w http.ResponseWriter, req *http.Request, registry *ConnRegistry) (io.ReadCloser, io.Writer, error) {
+	hijacker, ok := findHijacker(w)
+	if !ok {
+		statusCode := http.StatusInternalServerError
+		if req != nil && req.ProtoMajor >= 2 {
+			w.Header().Set("Upgrade", "HTTP/1.1")
+			statusCode = http.StatusUpgradeRequired
+		}
+		http.Error(w, "Streaming requires a connection that can be hijacked (HTTP/1.1)", statusCode)
+		return nil, nil, ErrHijackUnsupported
+	}
+	if err := registry.reserve(); err != nil {
+		return nil, nil, err
+	}
+	conn, brw, err := hijacker.Hijack()
 	if err != nil {
+		registry.release()
 		return nil, nil, err
 	}
-	// Flush the options to make sure the client sets the raw mode
-	conn.Write([]byte{})
+	stream := registry.track(&bufferedConn{conn, brw.Reader}, req)
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		stream.Close()
+		return nil, nil, err
+	}
+	// Flush anything already buffered for the client
+	if err := brw.Flush(); err != nil {
+		stream.Close()
+		return nil, nil, err
+	}
+	return stream, stream, nil
+}
+
+// HijackServerResponse hijacks the connection like HijackServer and writes a proper HTTP response onto it
+// before returning the streams, so the client receives a status line and headers.
+// statusCode must be http.StatusSwitchingProtocols, for which the upgrade headers are added, or http.StatusOK,
+// for which the Content-Type defaults to RawStreamContentType. The headers set on w and the given header
+// (which may be nil) are sent with the response.
+func HijackServerResponse(w http.ResponseWriter, statusCode int, header http.Header) (io.ReadCloser, io.Writer, error) {
+	return HijackServerWithOptions(w, HijackServerOptions{StatusCode: statusCode, Header: header})
+}
+
+// HijackServerWithOptions hijacks the connection like HijackServer, writes the response described by the given options
+// (if any) and applies their timeouts to the returned streams.
+// A request rejected by the Auth function of the options is answered and its error is returned.
+func HijackServerWithOptions(w http.ResponseWriter, options HijackServerOptions) (io.ReadCloser, io.Writer, error) {
+	if options.Auth != nil {
+		if options.Request == nil {
+			return nil, nil, errors.New("Auth requires the Request of the options")
+		}
+		if err := options.Auth(options.Request); err != nil {
+			writeAuthError(w, err)
+			return nil, nil, &rejectedError{err}
+		}
+	}
+	registry := options.Registry
+	if registry == nil {
+		registry = DefaultConnRegistry
+	}
+	var in io.ReadCloser
+	var responseHeader http.Header
+	if options.Request != nil && !canHijack(w, options.Request) {
+		// The response has been written by flushStreams
+		var err error
+		if in, err = flushStreams(w, options, registry); err != nil {
+			return nil, nil, err
+		}
+	} else if options.StatusCode != 0 {
+		responseHeader = w.Header().Clone()
+		for k, values := range options.Header {
+			responseHeader[k] = values
+		}
+		switch options.StatusCode {
+		case http.StatusSwitchingProtocols:
+			responseHeader.Set("Connection", "Upgrade")
+			if responseHeader.Get("Upgrade") == "" {
+				responseHeader.Set("Upgrade", "tcp")
+			}
+		case http.StatusOK:
+			if responseHeader.Get("Content-Type") == "" {
+				responseHeader.Set("Content-Type", RawStreamContentType)
+			}
+		}
+	}
+
+	if in == nil {
+		var err error
+		if in, _, err = hijackServer(w, options.Request, registry); err != nil {
+			return nil, nil, err
+		}
+	}
+	conn := in.(net.Conn)
+	var lock writeLock // Set if callbacks write to the connection
+	if options.ReadTimeout > 0 || options.WriteTimeout > 0 {
+		conn = &deadlineConn{Conn: conn, readTimeout: options.ReadTimeout, writeTimeout: options.WriteTimeout}
+	}
+	if options.ReadRate > 0 || options.WriteRate > 0 {
+		limited := &rateLimitedConn{Conn: conn}
+		if options.ReadRate > 0 {
+			limited.read = newTokenBucket(options.ReadRate)
+		}
+		if options.WriteRate > 0 {
+			limited.write = newTokenBucket(options.WriteRate)
+		}
+		conn = limited
+	}
+	if options.IdleTimeout > 0 {
+		tracked := in.(*trackedConn)
+		if options.OnIdle != nil && lock == nil {
+			lock = newWriteLock()
+		}
+		conn = newIdleConn(conn, options.IdleTimeout, func(w io.Writer) {
+			tracked.setCloseReason(CloseReasonIdleTimeout)
+			if options.OnIdle != nil {
+				// The timer runs concurrently to the handler, so its writes must not interleave with frames of it
+				options.OnIdle(&lockedWriter{w, lock})
+			}
+		})
+	}
+	if options.MaxDuration > 0 {
+		tracked := in.(*trackedConn)
+		warning := options.WarnBefore
+		if warning == 0 {
+			warning = DefaultMaxDurationWarning
+		}
+		var onWarning func(w io.Writer)
+		if options.OnMaxDuration != nil {
+			if lock == nil {
+				lock = newWriteLock()
+			}
+			onWarning = func(w io.Writer) {
+				// The timer runs concurrently to the handler, so its writes must not interleave with frames of it
+				options.OnMaxDuration(&lockedWriter{w, lock})
+			}
+		}
+		conn = newMaxDurationConn(conn, options.MaxDuration, warning, onWarning, func() {
+			tracked.setCloseReason(CloseReasonMaxDuration)
+		})
+	}
+	if options.MaxBytesIn > 0 || options.MaxBytesOut > 0 {
+		tracked := in.(*trackedConn)
+		unlimited := conn
+		// The close reason is sent in an exit frame, which only exists in the multiplexed format (without TTY)
+		sendReason := options.Request != nil && ExitCodeRequested(options.Request)
+		if sendReason {
+			config, _ := ParseStreamConfig(options.Request)
+			sendReason = !config.TTY
+		}
+		if sendReason && lock == nil {
+			lock = newWriteLock()
+		}
+		conn = newQuotaConn(conn, options.MaxBytesIn, options.MaxBytesOut, func(writing bool) {
+			tracked.setCloseReason(CloseReasonQuotaExceeded)
+			if !sendReason {
+				return
+			}
+			if writing {
+				// The writer of the connection already holds the write lock
+				WriteCloseReason(unlimited, CloseReasonQuotaExceeded)
+			} else {
+				WriteCloseReason(&lockedWriter{unlimited, lock}, CloseReasonQuotaExceeded)
+			}
+		})
+	}
+	if lock != nil {
+		conn = &lockedConn{conn, lock}
+	}
+	if responseHeader != nil {
+		bw := bufio.NewWriter(conn)
+		fmt.Fprintf(bw, "HTTP/1.1 %d %s\r\n", options.StatusCode, http.StatusText(options.StatusCode))
+		responseHeader.Write(bw)
+		bw.WriteString("\r\n")
+		if err := bw.Flush(); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
 	return conn, conn, nil
 }
 
-func CloseStreams(streams ...interface{}) {
-	for _, stream := range streams {
+// canHijack returns true if the connection of the given request can be hijacked.
+func canHijack(w http.ResponseWriter, req *http.Request) bool {
+	_, ok := findHijacker(w)
+	return ok && req.ProtoMajor < 2
+}
+
+// findHijacker returns the http.Hijacker of the given response writer, unwrapping response writers of middleware
+// that support it (like http.ResponseController).
+func findHijacker(w http.ResponseWriter) (http.Hijacker, bool) {
+	for {
+		if hijacker, ok := w.(http.Hijacker); ok {
+			return hijacker, true
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil, false
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
+// flushStreams returns the body of the request in the given options (as reads) and the response (as writes, every
+// write flushed) as connection tracked by the given registry, so the limits of the options apply like to hijacked
+// connections. The response is sent with status 200, since upgrades are not possible.
+// The handler must not return before streaming has finished.
+func flushStreams(w http.ResponseWriter, options HijackServerOptions, registry *ConnRegistry) (*trackedConn, error) {
+	if err := registry.reserve(); err != nil {
+		return nil, err
+	}
+	rc := http.NewResponseController(w)
+	if options.Request.ProtoMajor < 2 {
+		// Allow reading the request body while writing the response
+		if err := rc.EnableFullDuplex(); err != nil {
+			registry.release()
+			return nil, err
+		}
+	}
+	// Like for hijacked connections, deadlines of the http.Server must not end long-lived streams
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	for k, values := range options.Header {
+		w.Header()[k] = values
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", RawStreamContentType)
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		registry.release()
+		return nil, err
+	}
+	return registry.track(&flushConn{body: options.Request.Body, w: w, rc: rc}, options.Request), nil
+}
+
+// flushConn is a connection reading the body of a request and writing its response, flushing every write.
+// Once closed (for writing), writes fail, since the response only ends when the handler returns.
+type flushConn struct {
+	body io.ReadCloser
+	w    io.Writer
+	rc   *http.ResponseController
+
+	mutex       sync.Mutex // Held by writes, the response must not be written once the handler may have returned
+	writeClosed bool
+
+	closeMutex sync.Mutex // Held while using the response controller, which must not be used once closed
+	closed     bool
+}
+
+func (c *flushConn) Read(p []byte) (int, error) {
+	return c.body.Read(p)
+}
+
+func (c *flushConn) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.writeClosed {
+		return 0, net.ErrClosed
+	}
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.rc.Flush()
+}
+
+func (c *flushConn) CloseWrite() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.writeClosed = true
+	return nil
+}
+
+func (c *flushConn) Close() error {
+	c.closeMutex.Lock()
+	if c.closed {
+		c.closeMutex.Unlock()
+		return nil
+	}
+	c.closed = true
+	// Unblock a pending write, e.g. to a stalled client
+	c.rc.SetWriteDeadline(time.Now())
+	c.closeMutex.Unlock()
+	c.CloseWrite()
+	return c.body.Close()
+}
+
+func (c *flushConn) LocalAddr() net.Addr  { return streamAddr{} }
+func (c *flushConn) RemoteAddr() net.Addr { return streamAddr{} }
+
+func (c *flushConn) SetDeadline(t time.Time) error {
+	return errors.Join(c.SetReadDeadline(t), c.SetWriteDeadline(t))
+}
+
+func (c *flushConn) SetReadDeadline(t time.Time) error {
+	c.closeMutex.Lock()
+	defer c.closeMutex.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	return c.rc.SetReadDeadline(t)
+}
+
+func (c *flushConn) SetWriteDeadline(t time.Time) error {
+	c.closeMutex.Lock()
+	defer c.closeMutex.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	return c.rc.SetWriteDeadline(t)
+}
+
+// CloseStreams closes the given streams in the given order: streams supporting it are closed for writing
+// (signalling the end of the stream to the client), other closers are closed. A stream given more than once
+// (e.g. the same connection as input and output stream) is only closed once. Connections of HijackServer closed for
+// writing are closed completely (ending their tracking by the registry) once their input stream has been read to its
+// end, otherwise the input stream has to be closed as well.
+// The errors of all streams are returned joined.
+func CloseStreams(streams ...interface{}) error {
+	var errs []error
+	for i, stream := range streams {
+		if closedBefore(streams[:i], stream) {
+			continue
+		}
 		if tcpc, ok := stream.(closeWriter); ok {
-			tcpc.CloseWrite()
+			errs = append(errs, tcpc.CloseWrite())
 		} else if closer, ok := stream.(io.Closer); ok {
-			closer.Close()
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CloseStreamsContext closes the given streams like CloseStreams, but bounded by the given context, so a wedged
+// client cannot block forever: the deadline of the context is set as write deadline on streams supporting it,
+// and if the context is done before closing has finished, the streams are closed forcibly and the error of the
+// context is returned.
+func CloseStreamsContext(ctx context.Context, streams ...interface{}) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		for _, stream := range streams {
+			if d, ok := stream.(interface{ SetWriteDeadline(time.Time) error }); ok {
+				d.SetWriteDeadline(deadline)
+			}
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- CloseStreams(streams...)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		for i, stream := range streams {
+			if closer, ok := stream.(io.Closer); ok && !closedBefore(streams[:i], stream) {
+				closer.Close()
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+// closedBefore returns true if the given stream is one of the given streams.
+func closedBefore(streams []interface{}, stream interface{}) bool {
+	if stream == nil || !reflect.TypeOf(stream).Comparable() {
+		return false
+	}
+	for _, s := range streams {
+		if s == stream {
+			return true
 		}
 	}
+	return false
 }
@@ -0,0 +1,292 @@
+package support
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Session is a hijacked connection whose data is streamed in the background.
+type Session struct {
+	options HijackHttpOptions
+
+	upgraded      chan struct{} // Closed once the connection has been hijacked
+	upgradeOnce   sync.Once
+	done          chan struct{} // Closed once streaming has finished
+	err           error
+	mutex         sync.Mutex
+	conn          io.Closer
+	terminal      *Terminal
+	exitStatus    *ExitStatus
+	stderr        *bytes.Buffer
+	mux           *yamux.Session
+	detach        bool     // If set, the hijacked connection is handed over to the caller instead of streaming
+	detached      net.Conn // The handed over connection
+	input         io.Writer
+	channelSender func(channel byte, data []byte) error
+	traceCtx      context.Context // Context of the span of the session, if traced
+	span          trace.Span
+	streamSpan    trace.Span
+	bytesIn       int64 // Bytes read from the hijacked connection, counted if countsBytes
+	bytesOut      int64 // Bytes of the input stream written to the hijacked connection, counted if countsBytes
+	started       time.Time
+	phase         string // Phase of the session (e.g. dial), the class of an error in metrics
+	phaseStarted  time.Time
+	timings       Timings
+	recorder      Recorder // Recorder of the streams, if any
+}
+
+var (
+	ErrResizeUnsupported = errors.New("Resize not supported")
+	ErrSignalUnsupported = errors.New("Signal not supported")
+)
+
+// ResizeFunc resizes the TTY of the remote process of the given session.
+type ResizeFunc func(s *Session, width, height uint) error
+
+// newSession creates a session for the given options.
+func newSession(options HijackHttpOptions) *Session {
+	s := &Session{
+		upgraded: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if options.StderrAsError {
+		s.stderr = &bytes.Buffer{}
+		if options.ErrorStream != nil {
+			options.ErrorStream = io.MultiWriter(options.ErrorStream, s.stderr)
+		} else {
+			options.ErrorStream = s.stderr
+		}
+	}
+	s.options = options
+	s.started = time.Now()
+	s.phase = ErrorClassRequest
+	s.startTrace()
+	options.Metrics.sessionStarted()
+	return s
+}
+
+// finish returns the final error of the session, given the error of streaming.
+func (s *Session) finish(err error) error {
+	if err == nil && s.stderr != nil && s.stderr.Len() > 0 {
+		err = &StderrError{Output: s.stderr.String()}
+	}
+	s.endTrace(err)
+	s.options.Metrics.sessionEnded(s, err)
+	s.statsClosed(err)
+	err = s.wrapError(err)
+	if s.options.OnClose != nil {
+		s.options.OnClose(s, err)
+	}
+	return err
+}
+
+// start runs fn in the background and waits until the connection has been hijacked or fn has returned.
+func (s *Session) start(fn func() error) error {
+	go func() {
+		s.err = s.finish(fn())
+		close(s.done)
+	}()
+	select {
+	case <-s.upgraded:
+	case <-s.done:
+		return s.err
+	}
+
+	if s.options.RawTerminal != nil {
+		terminal, err := MakeRawTerminal(int(s.options.RawTerminal.Fd()))
+		if err != nil {
+			s.Close()
+			return err
+		}
+		s.mutex.Lock()
+		s.terminal = terminal
+		s.mutex.Unlock()
+		go func() {
+			<-s.done
+			s.RestoreTerminal()
+		}()
+	}
+	return nil
+}
+
+// upgrade marks the session as hijacked, conn is closed when the session is closed.
+// Input is the writer for in-band data to the input stream of the remote process, if any.
+func (s *Session) upgrade(conn io.Closer, input io.Writer) {
+	s.mutex.Lock()
+	s.conn = conn
+	s.input = input
+	s.mutex.Unlock()
+	s.upgradeOnce.Do(func() {
+		if !s.detach {
+			s.traceStream()
+		}
+		if s.options.OnUpgraded != nil {
+			s.options.OnUpgraded(s)
+		}
+		close(s.upgraded)
+	})
+}
+
+// connected calls the OnConnect callback of the options, if any.
+func (s *Session) connected() {
+	if s.options.OnConnect != nil {
+		s.options.OnConnect(s)
+	}
+}
+
+// detachConn hands the given hijacked connection over to the caller.
+func (s *Session) detachConn(conn net.Conn) {
+	s.mutex.Lock()
+	s.detached = conn
+	s.mutex.Unlock()
+	s.upgrade(conn, conn)
+}
+
+// setChannelSender sets the function used to send in-band control frames on a channel.
+func (s *Session) setChannelSender(fn func(channel byte, data []byte) error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.channelSender = fn
+}
+
+// Wait waits until streaming has finished and returns its error.
+func (s *Session) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Done returns a channel that is closed when streaming has finished.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// RestoreTerminal restores the RawTerminal of the options from raw mode.
+// This happens automatically when streaming has finished, callers of StartHijackHttpRequest
+// should defer it to restore the terminal on panics as well.
+func (s *Session) RestoreTerminal() error {
+	s.mutex.Lock()
+	terminal := s.terminal
+	s.mutex.Unlock()
+	if terminal == nil {
+		return nil
+	}
+	return terminal.Restore()
+}
+
+// ExitCode returns the exit code of the remote process as reported in the exit frame of the stream.
+// The second return value is false if no exit code has been received (yet).
+func (s *Session) ExitCode() (int, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.exitStatus == nil {
+		return 0, false
+	}
+	return s.exitStatus.ExitCode, true
+}
+
+// ExitStatus returns the exit status of the remote process (including the terminating signal) as reported
+// in the exit frame of the stream. The second return value is false if no exit status has been received (yet).
+func (s *Session) ExitStatus() (ExitStatus, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.exitStatus == nil {
+		return ExitStatus{}, false
+	}
+	return *s.exitStatus, true
+}
+
+// setExitStatus stores the exit status from the given exit frame payload.
+// A non-zero exit code is returned as *ExitError, a close reason as *ClosedError.
+func (s *Session) setExitStatus(payload []byte) error {
+	if len(payload) == 0 {
+		return nil
+	}
+	var status ExitStatus
+	if err := json.Unmarshal(payload, &status); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.exitStatus = &status
+	s.mutex.Unlock()
+	if status.CloseReason != "" {
+		return &ClosedError{Reason: status.CloseReason}
+	}
+	if status.ExitCode != 0 || status.Signal != "" {
+		return &ExitError{Code: status.ExitCode, Signal: status.Signal}
+	}
+	return nil
+}
+
+// Close closes the hijacked connection, which ends streaming.
+func (s *Session) Close() error {
+	s.RestoreTerminal()
+	s.mutex.Lock()
+	conn := s.conn
+	s.mutex.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+// Resize resizes the TTY of the remote process to the given dimensions using the ResizeFunc of the options.
+// Without ResizeFunc, sessions using the ChannelProtocol are resized in-band.
+func (s *Session) Resize(width, height uint) error {
+	var err error
+	switch {
+	case s.options.ResizeFunc != nil:
+		err = s.options.ResizeFunc(s, width, height)
+	case s.options.ChannelProtocol:
+		err = ChannelProtocolResize(s, width, height)
+	default:
+		return ErrResizeUnsupported
+	}
+	s.mutex.Lock()
+	recorder := s.recorder
+	s.mutex.Unlock()
+	if resizer, ok := recorder.(resizeRecorder); ok && err == nil {
+		err = resizer.Resize(width, height)
+	}
+	return err
+}
+
+// Signal forwards the given signal to the remote process using the SignalFunc of the options.
+func (s *Session) Signal(sig os.Signal) error {
+	if s.options.SignalFunc != nil {
+		return s.options.SignalFunc(s, sig)
+	}
+	return ErrSignalUnsupported
+}
+
+// writeInput writes the given data in-band to the input stream of the remote process.
+func (s *Session) writeInput(data []byte) error {
+	s.mutex.Lock()
+	input := s.input
+	s.mutex.Unlock()
+	if input == nil {
+		return ErrSignalUnsupported
+	}
+	_, err := input.Write(data)
+	return err
+}
+
+// sendChannel sends the given data in-band on the given channel.
+func (s *Session) sendChannel(channel byte, data []byte) error {
+	s.mutex.Lock()
+	write := s.channelSender
+	s.mutex.Unlock()
+	if write == nil {
+		return ErrResizeUnsupported
+	}
+	return write(channel, data)
+}
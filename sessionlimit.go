@@ -0,0 +1,128 @@
+package support
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrRateLimited = errors.New("Too many sessions")
+
+// SessionLimiter limits the sessions started by every client, identified by the key returned by its Key function.
+// Its Middleware rejects requests beyond the limits with 429 Too Many Requests before they are hijacked.
+type SessionLimiter struct {
+	PerMinute     int                            // If set, a client can start at most this many sessions within a minute.
+	MaxConcurrent int                            // If set, a client can have at most this many sessions at once.
+	Key           func(req *http.Request) string // Returns the key identifying the client of a request, defaults to RemoteIPKey.
+
+	mutex     sync.Mutex
+	clients   map[string]*clientLimit
+	lastSweep time.Time
+}
+
+// clientLimit is the state of a client of a SessionLimiter.
+type clientLimit struct {
+	starts []time.Time // Start times of the sessions within the last minute, oldest first
+	active int
+}
+
+// RemoteIPKey returns the IP address of the client of the given request (without port) as key for a SessionLimiter.
+func RemoteIPKey(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// AuthorizationKey returns the Authorization header of the given request (e.g. the bearer token) as key for
+// a SessionLimiter, falling back to the IP address for requests without one.
+func AuthorizationKey(req *http.Request) string {
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		return "auth:" + auth
+	}
+	return "ip:" + RemoteIPKey(req)
+}
+
+// Middleware returns middleware that counts the requests passed to next as sessions of their client, a session
+// lasting until next returns. Requests exceeding the limits are answered with 429 Too Many Requests (with
+// a Retry-After header if PerMinute is exceeded) and not passed to next.
+func (l *SessionLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := RemoteIPKey(req)
+		if l.Key != nil {
+			key = l.Key(req)
+		}
+		if retryAfter, ok := l.acquire(key); !ok {
+			if retryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+			}
+			http.Error(w, ErrRateLimited.Error(), http.StatusTooManyRequests)
+			return
+		}
+		defer l.release(key)
+		next.ServeHTTP(w, req)
+	})
+}
+
+// acquire starts a session of the client with the given key. If a limit is exceeded, false is returned together
+// with the time until the next session can be started (0 if the concurrency limit is exceeded).
+func (l *SessionLimiter) acquire(key string) (time.Duration, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	if l.clients == nil {
+		l.clients = make(map[string]*clientLimit)
+	}
+	if now.Sub(l.lastSweep) > time.Minute {
+		// Forget clients without sessions, so the map does not grow with every client ever seen
+		for k, client := range l.clients {
+			if client.expire(now); client.idle() {
+				delete(l.clients, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	client, ok := l.clients[key]
+	if !ok {
+		client = &clientLimit{}
+		l.clients[key] = client
+	}
+	client.expire(now)
+	if l.MaxConcurrent > 0 && client.active >= l.MaxConcurrent {
+		return 0, false
+	}
+	if l.PerMinute > 0 && len(client.starts) >= l.PerMinute {
+		return client.starts[0].Add(time.Minute).Sub(now), false
+	}
+	client.active++
+	client.starts = append(client.starts, now)
+	return 0, true
+}
+
+// release ends a session of the client with the given key.
+func (l *SessionLimiter) release(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if client, ok := l.clients[key]; ok {
+		client.active--
+	}
+}
+
+// expire forgets the sessions started more than a minute before the given time.
+func (c *clientLimit) expire(now time.Time) {
+	i := 0
+	for i < len(c.starts) && now.Sub(c.starts[i]) >= time.Minute {
+		i++
+	}
+	c.starts = c.starts[i:]
+}
+
+// idle returns true if the client has no sessions.
+func (c *clientLimit) idle() bool {
+	return c.active == 0 && len(c.starts) == 0
+}
@@ -0,0 +1,587 @@
+package support
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSessionGracePeriod is the time a session of a SessionManager survives without attached client by default.
+	DefaultSessionGracePeriod = time.Minute
+	// DefaultSessionBufferSize is the maximum size of the output buffered for a detached session by default.
+	DefaultSessionBufferSize = 1024 * 1024
+	// DefaultSlowClientTimeout is the time output waits for a client that does not keep up by default.
+	DefaultSlowClientTimeout = 5 * time.Second
+	// DefaultMaxSessions is the maximum number of sessions a SessionManager keeps at once by default.
+	DefaultMaxSessions = 1024
+)
+
+var (
+	ErrSessionNotFound = errors.New("Session not found")
+	ErrSessionAttached = errors.New("Session already attached")
+	ErrTooManySessions = errors.New("Session limit reached")
+)
+
+// SessionManager runs the handlers of its Handler as sessions that survive the connection of their client:
+// when the client disconnects, the handler keeps running for a grace period with its output buffered, and
+// a new request carrying the id of the session re-attaches to it, receiving the buffered output first.
+// Only the client that started a session (as identified by Owner) can attach to it.
+type SessionManager struct {
+	GracePeriod       time.Duration                  // How long a session survives without attached client, defaults to DefaultSessionGracePeriod.
+	BufferSize        int                            // Maximum size of the output buffered while no client is attached, defaults to DefaultSessionBufferSize. The oldest output is dropped beyond it. It is also the size of the output queued for each attached client.
+	SlowClientTimeout time.Duration                  // How long output waits for an attached client with a full queue before it is detached as too slow, defaults to DefaultSlowClientTimeout.
+	MultiAttach       bool                           // If set, several clients can attach to a session at once (like docker attach from several terminals), the output is sent to all of them.
+	SharedInput       bool                           // If set, the input of all attached clients is passed to the handler, otherwise only the input of the client attached the longest.
+	MaxSessions       int                            // Maximum number of sessions (attached or detached) at once, defaults to DefaultMaxSessions. Further requests starting a session are answered with 503 Service Unavailable.
+	Owner             func(req *http.Request) string // Returns the identity of the client of a request, only requests of the client that started a session can attach to it. Defaults to the fingerprint of the verified client certificate, or AuthorizationKey without one.
+
+	mutex    sync.Mutex
+	sessions map[string]*streamSession
+}
+
+// NewSessionManager creates a manager without sessions.
+func NewSessionManager() *SessionManager {
+	return &SessionManager{sessions: make(map[string]*streamSession)}
+}
+
+// Handler returns an http.Handler that serves fn like HijackHandler (without websocket and SSE support), but runs
+// it as a session. Requests without session id start a new session, whose id is returned in the SessionHeader
+// of the response. Requests carrying the id of a session in the SessionHeader or the `session` query parameter
+// attach to it, answered with 404 Not Found if the session does not exist (anymore) or was started by another
+// client and 409 Conflict if another client with an open input stream is attached (unless MultiAttach is set).
+// The stream config of a session is taken from the request starting it.
+// A client ending its input stream stays attached to receive the output. A client is detached when its connection
+// fails or writing output to it fails, output written afterwards is buffered for the next client. The input stream
+// of the handler is closed when the session expires. The context passed to fn is cancelled when the session expires
+// or Shutdown is called.
+func (m *SessionManager) Handler(fn StreamHandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = withClientIdentity(req)
+		s, created, err := m.session(req)
+		if err == ErrSessionNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err == ErrSessionAttached {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err == ErrTooManySessions {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set(SessionHeader, s.id)
+		in, out, err := HijackServerWithOptions(w, HijackServerOptions{StatusCode: responseStatusCode(req), Request: req})
+		if err != nil {
+			if created {
+				m.remove(s)
+				s.cancel()
+			} else {
+				s.release()
+			}
+			hijackError(w, err)
+			return
+		}
+		defer in.Close()
+		defer CloseStreams(in, out)
+
+		client := s.attach(out, ExitCodeRequested(req))
+		if created {
+			go s.run(fn)
+		}
+		go s.copyInput(client, in)
+		<-client.gone
+	})
+}
+
+// session returns the session requested by the given request, creating it if the request carries no session id.
+// The session is reserved for the client of the request.
+func (m *SessionManager) session(req *http.Request) (*streamSession, bool, error) {
+	owner := m.owner(req)
+	if id := sessionID(req); id != "" {
+		m.mutex.Lock()
+		s, ok := m.sessions[id]
+		m.mutex.Unlock()
+		// Sessions of other clients are not revealed
+		if !ok || s.owner != owner {
+			return nil, false, ErrSessionNotFound
+		}
+		if !s.reserve() {
+			return nil, false, ErrSessionAttached
+		}
+		return s, false, nil
+	}
+
+	config, err := ParseStreamConfig(req)
+	if err != nil {
+		return nil, false, err
+	}
+	id, err := newSessionID()
+	if err != nil {
+		return nil, false, err
+	}
+	// The session outlives the request, so its context must not be cancelled with it
+	ctx, cancel := DefaultConnRegistry.notify(context.WithValue(context.WithoutCancel(req.Context()), streamConfigContextKey{}, config))
+	s := &streamSession{id: id, owner: owner, manager: m, config: config, ctx: ctx, cancel: cancel, pending: 1}
+	s.output, s.input = io.Pipe()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.sessions) >= m.maxSessions() {
+		cancel()
+		return nil, false, ErrTooManySessions
+	}
+	if m.sessions == nil {
+		m.sessions = make(map[string]*streamSession)
+	}
+	m.sessions[id] = s
+	return s, true, nil
+}
+
+// remove removes the given session.
+func (m *SessionManager) remove(s *streamSession) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.sessions[s.id] == s {
+		delete(m.sessions, s.id)
+	}
+}
+
+// gracePeriod returns the grace period of detached sessions.
+func (m *SessionManager) gracePeriod() time.Duration {
+	if m.GracePeriod > 0 {
+		return m.GracePeriod
+	}
+	return DefaultSessionGracePeriod
+}
+
+// maxSessions returns the maximum number of sessions at once.
+func (m *SessionManager) maxSessions() int {
+	if m.MaxSessions > 0 {
+		return m.MaxSessions
+	}
+	return DefaultMaxSessions
+}
+
+// owner returns the identity of the client of the given request.
+func (m *SessionManager) owner(req *http.Request) string {
+	if m.Owner != nil {
+		return m.Owner(req)
+	}
+	if identity, ok := ClientIdentityFromRequest(req); ok {
+		fingerprint := sha256.Sum256(identity.Certificate.Raw)
+		return "cert:" + hex.EncodeToString(fingerprint[:])
+	}
+	return AuthorizationKey(req)
+}
+
+// slowClientTimeout returns the time output waits for a client with a full queue.
+func (m *SessionManager) slowClientTimeout() time.Duration {
+	if m.SlowClientTimeout > 0 {
+		return m.SlowClientTimeout
+	}
+	return DefaultSlowClientTimeout
+}
+
+// bufferSize returns the maximum size of the output buffered for detached sessions.
+func (m *SessionManager) bufferSize() int {
+	if m.BufferSize > 0 {
+		return m.BufferSize
+	}
+	return DefaultSessionBufferSize
+}
+
+// streamSession is a session of a SessionManager.
+type streamSession struct {
+	id      string
+	owner   string // Identity of the client that started the session
+	manager *SessionManager
+	config  StreamConfig
+	ctx     context.Context
+	cancel  context.CancelFunc
+	input   *io.PipeWriter // Written by the attached clients
+	output  *io.PipeReader // Read by the handler
+
+	mutex    sync.Mutex
+	pending  int              // Number of clients that reserved the session and are still hijacking their connection
+	clients  []*sessionClient // The attached clients in the order they attached
+	buffered [][]byte         // Output written while detached, each element a complete write (or frame)
+	size     int
+	done     bool
+	status   ExitStatus
+	timer    *time.Timer
+}
+
+// sessionClient is a client attached to a session. Its output is queued and written by its own goroutine
+// (see sendOutput), so a stalled client blocks neither the handler nor the other clients.
+type sessionClient struct {
+	w        io.Writer
+	exitCode bool          // The client asked for an exit frame
+	gone     chan struct{} // Closed when the client has been detached
+	ready    chan struct{} // Signalled when output has been queued
+	drained  chan struct{} // Signalled when the queue has been taken
+
+	// Guarded by the mutex of the session
+	queue    [][]byte // Output not yet taken by the goroutine of the client
+	queued   int      // Size of the queue
+	finished bool     // The session has finished, the client is detached once its queue has been written
+	// Once the input of the client has ended, it may have closed its connection without the writes failing yet,
+	// so the output sent since is kept (up to the buffer size) for the next client.
+	inputEnded      bool
+	unconfirmed     [][]byte
+	unconfirmedSize int
+}
+
+// run calls fn with the streams of the session and finishes the session when it returns.
+func (s *streamSession) run(fn StreamHandlerFunc) {
+	defer s.cancel()
+	var in io.Reader = s.output
+	stdout, stderr := io.Writer(&sessionWriter{s, StdoutStream}), io.Writer(&sessionWriter{s, StderrStream})
+	if !s.config.Stdin {
+		in = http.NoBody
+	}
+	if !s.config.Stdout {
+		stdout = io.Discard
+	}
+	if !s.config.Stderr {
+		stderr = io.Discard
+	}
+	status := handlerExitStatus(fn(s.ctx, in, stdout, stderr), stderr)
+	// Unblock clients still writing input
+	s.output.Close()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.done = true
+	s.status = status
+	if len(s.clients) > 0 {
+		for _, client := range s.clients {
+			s.finish(client)
+		}
+		s.manager.remove(s)
+	}
+}
+
+// reserve reserves the session for a new client, returning false if another client is attached and the manager
+// does not allow several clients. Attached clients whose input has ended are detached in favour of the new client,
+// as they may have closed their connection without noticing it.
+func (s *streamSession) reserve() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.manager.MultiAttach && !s.idle() {
+		if s.pending > 0 || !s.inputEnded() {
+			return false
+		}
+		for len(s.clients) > 0 {
+			s.detachLocked(s.clients[0])
+		}
+	}
+	s.pending++
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	return true
+}
+
+// release releases the reservation of a client that failed to attach.
+func (s *streamSession) release() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pending--
+	if s.idle() {
+		s.startTimer()
+	}
+}
+
+// inputEnded returns true if the input of all attached clients has ended. The caller must hold the mutex of the session.
+func (s *streamSession) inputEnded() bool {
+	for _, client := range s.clients {
+		if !client.inputEnded {
+			return false
+		}
+	}
+	return true
+}
+
+// idle returns true if no client is attached or about to attach.
+func (s *streamSession) idle() bool {
+	return s.pending == 0 && len(s.clients) == 0
+}
+
+// attach attaches the client with the given output stream to the reserved session, sending it the buffered output
+// (and the exit frame if the session has already finished).
+func (s *streamSession) attach(w io.Writer, exitCode bool) *sessionClient {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	client := &sessionClient{w: w, exitCode: exitCode, gone: make(chan struct{}), ready: make(chan struct{}, 1), drained: make(chan struct{}, 1)}
+	s.pending--
+	s.clients = append(s.clients, client)
+	go s.sendOutput(client)
+	for _, p := range s.buffered {
+		s.enqueue(client, p)
+	}
+	s.buffered, s.size = nil, 0
+	if s.done {
+		s.finish(client)
+		s.manager.remove(s)
+	}
+	return client
+}
+
+// enqueue queues the given output for the given client. The caller must hold the mutex of the session.
+func (s *streamSession) enqueue(client *sessionClient, p []byte) {
+	client.queue = append(client.queue, p)
+	client.queued += len(p)
+	if client.inputEnded {
+		client.unconfirmed = append(client.unconfirmed, p)
+		client.unconfirmedSize += len(p)
+		for limit := s.manager.bufferSize(); client.unconfirmedSize > limit && len(client.unconfirmed) > 1; {
+			client.unconfirmedSize -= len(client.unconfirmed[0])
+			client.unconfirmed = client.unconfirmed[1:]
+		}
+	}
+	select {
+	case client.ready <- struct{}{}:
+	default:
+	}
+}
+
+// finish queues the exit frame for the given client if it asked for one (and the output is multiplexed), so it is
+// detached once it has received all output. The caller must hold the mutex of the session.
+func (s *streamSession) finish(client *sessionClient) {
+	if client.exitCode && !s.config.TTY {
+		var buf bytes.Buffer
+		writeExitStatus(&buf, s.status)
+		client.queue = append(client.queue, buf.Bytes())
+	}
+	client.finished = true
+	s.enqueue(client, nil)
+}
+
+// sendOutput writes the output queued for the given client until it is detached. Output is written without holding
+// the mutex of the session, a client failing to receive it is detached.
+func (s *streamSession) sendOutput(client *sessionClient) {
+	for {
+		select {
+		case <-client.ready:
+		case <-client.gone:
+			return
+		}
+		s.mutex.Lock()
+		queue, finished := client.queue, client.finished
+		client.queue, client.queued = nil, 0
+		s.mutex.Unlock()
+		select {
+		case client.drained <- struct{}{}:
+		default:
+		}
+		for _, p := range queue {
+			if len(p) == 0 {
+				continue
+			}
+			if _, err := client.w.Write(p); err != nil {
+				s.detach(client)
+				return
+			}
+		}
+		if finished {
+			s.detach(client)
+			return
+		}
+	}
+}
+
+// detach detaches the given client if it is still attached, starting the grace period of the session
+// if it was the last one.
+func (s *streamSession) detach(client *sessionClient) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.detachLocked(client)
+}
+
+func (s *streamSession) detachLocked(client *sessionClient) {
+	for i, c := range s.clients {
+		if c != client {
+			continue
+		}
+		s.clients = append(s.clients[:i:i], s.clients[i+1:]...)
+		close(client.gone)
+		if len(s.clients) == 0 && !s.done {
+			// Output not yet sent to the last client (or not known to have arrived) is kept for the next one
+			if client.inputEnded {
+				s.buffered = append(client.unconfirmed, s.buffered...)
+				s.size += client.unconfirmedSize
+			} else {
+				s.buffered = append(client.queue, s.buffered...)
+				s.size += client.queued
+			}
+			s.trimBuffer()
+		}
+		if s.idle() && !s.done {
+			s.startTimer()
+		}
+		return
+	}
+}
+
+// writesInput returns true if the input of the given client is passed to the handler: with SharedInput the input
+// of all clients, otherwise only the input of the designated writer, the client attached the longest whose input
+// has not ended.
+func (s *streamSession) writesInput(client *sessionClient) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.manager.SharedInput {
+		return true
+	}
+	for _, c := range s.clients {
+		if !c.inputEnded {
+			return c == client
+		}
+	}
+	return false
+}
+
+// endInput marks the input of the given client as ended. The output queued for it is kept from now on, as its
+// connection may be closed.
+func (s *streamSession) endInput(client *sessionClient) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	client.inputEnded = true
+	client.unconfirmed = append([][]byte(nil), client.queue...)
+	client.unconfirmedSize = client.queued
+}
+
+// copyInput passes the input of the given client to the handler (or discards it if the client is not allowed
+// to write) until it ends. The input of the session is not ended with it, so the client can re-attach later.
+// A client that ends its input (e.g. half-closing its connection) stays attached to receive the output, a failing
+// connection detaches it. A client that closed its connection cleanly cannot be told apart from a half-closing one,
+// it is detached once writing output to it fails or it re-attaches, the output sent since its input ended is
+// buffered for the next client.
+func (s *streamSession) copyInput(client *sessionClient, in io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 && s.writesInput(client) {
+			if _, err := s.input.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err == io.EOF {
+			s.endInput(client)
+			return
+		} else if err != nil {
+			s.detach(client)
+			return
+		}
+	}
+}
+
+// startTimer starts the grace period of the detached session, after which it expires.
+func (s *streamSession) startTimer() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer = time.AfterFunc(s.manager.gracePeriod(), s.expire)
+}
+
+// expire removes the session if no client has attached in the meantime, ending its input stream and cancelling its context.
+func (s *streamSession) expire() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.idle() {
+		return
+	}
+	s.manager.remove(s)
+	s.input.Close()
+	s.cancel()
+}
+
+// write queues the given output for all attached clients, or buffers it while the session is detached.
+// While the queue of a client is full, it waits for the client to catch up, detaching it as too slow if it
+// does not within the slow client timeout.
+func (s *streamSession) write(p []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	timeout := time.NewTimer(s.manager.slowClientTimeout())
+	defer timeout.Stop()
+	for full := s.fullClient(len(p)); full != nil; full = s.fullClient(len(p)) {
+		s.mutex.Unlock()
+		select {
+		case <-full.drained:
+		case <-full.gone:
+		case <-timeout.C:
+			s.mutex.Lock()
+			for full := s.fullClient(len(p)); full != nil; full = s.fullClient(len(p)) {
+				s.detachLocked(full)
+			}
+			s.mutex.Unlock()
+		}
+		s.mutex.Lock()
+	}
+	sent := false
+	for _, client := range s.clients {
+		s.enqueue(client, p)
+		sent = true
+	}
+	if sent {
+		return
+	}
+	s.buffered = append(s.buffered, p)
+	s.size += len(p)
+	s.trimBuffer()
+}
+
+// fullClient returns an attached client whose queue cannot take output of the given size, or nil.
+// The caller must hold the mutex of the session.
+func (s *streamSession) fullClient(size int) *sessionClient {
+	for _, client := range s.clients {
+		if client.queued > 0 && client.queued+size > s.manager.bufferSize() {
+			return client
+		}
+	}
+	return nil
+}
+
+// trimBuffer drops the oldest buffered output beyond the buffer size. The caller must hold the mutex of the session.
+func (s *streamSession) trimBuffer() {
+	for limit := s.manager.bufferSize(); s.size > limit && len(s.buffered) > 1; {
+		s.size -= len(s.buffered[0])
+		s.buffered = s.buffered[1:]
+	}
+}
+
+// sessionWriter is an output stream of a session. Every write is sent (or buffered) as a whole, multiplexed
+// unless the session is in TTY mode, so buffered output never splits a frame.
+type sessionWriter struct {
+	s      *streamSession
+	stream StdStream
+}
+
+func (w *sessionWriter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	if w.s.config.TTY {
+		buf.Write(p)
+	} else if _, err := NewStdWriter(&buf, w.stream).Write(p); err != nil {
+		return 0, err
+	}
+	w.s.write(buf.Bytes())
+	return len(p), nil
+}
+
+// newSessionID returns a new random session id.
+func newSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,91 @@
+package support
+
+import (
+	"net/http"
+	neturl "net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+// SignalFunc forwards the given signal to the remote process of the given session.
+type SignalFunc func(s *Session, sig os.Signal) error
+
+// DefaultForwardedSignals are the signals forwarded by ForwardSignals if none are given.
+var DefaultForwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT}
+
+// signalNames maps signals to the names used by the docker API.
+var signalNames = map[os.Signal]string{
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGTERM: "SIGTERM",
+}
+
+// ttySignalChars maps signals to the terminal control characters that raise them on a remote TTY.
+var ttySignalChars = map[os.Signal]byte{
+	syscall.SIGINT:  0x03, // Ctrl-C
+	syscall.SIGQUIT: 0x1c, // Ctrl-\
+}
+
+// ForwardSignals forwards the given local signals (DefaultForwardedSignals if none are given) to the remote process
+// of the given session, instead of handling them locally. Forwarding stops when the session is done.
+func ForwardSignals(s *Session, signals ...os.Signal) {
+	if len(signals) == 0 {
+		signals = DefaultForwardedSignals
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+	go func() {
+		defer signal.Stop(c)
+		for {
+			select {
+			case <-s.Done():
+				return
+			case sig := <-c:
+				if err := s.Signal(sig); err != nil {
+					levels(s.options.Log).Warnf("Forwarding signal %v failed %#v", sig, err)
+				}
+			}
+		}
+	}()
+}
+
+// TTYSignal is a SignalFunc that writes the terminal control character of the signal (e.g. Ctrl-C for SIGINT)
+// in-band to the input stream, so the remote TTY raises the signal for its foreground process.
+func TTYSignal(s *Session, sig os.Signal) error {
+	c, ok := ttySignalChars[sig]
+	if !ok {
+		return ErrSignalUnsupported
+	}
+	return s.writeInput([]byte{c})
+}
+
+// HTTPSignal returns a SignalFunc that posts the signal as `signal` query parameter to the given URL,
+// e.g. `/containers/{id}/kill` of the docker API.
+func HTTPSignal(url string, header http.Header) SignalFunc {
+	return func(s *Session, sig os.Signal) error {
+		ep, err := neturl.Parse(url)
+		if err != nil {
+			return err
+		}
+		endpoint, path := splitEndpointURL(ep)
+		client, err := newAPIClient(endpoint, header)
+		if err != nil {
+			return err
+		}
+		name, ok := signalNames[sig]
+		if !ok {
+			sysSig, ok := sig.(syscall.Signal)
+			if !ok {
+				return ErrSignalUnsupported
+			}
+			name = strconv.Itoa(int(sysSig))
+		}
+		query := ep.Query()
+		query.Set("signal", name)
+		return client.do("POST", path+"?"+query.Encode(), nil, nil)
+	}
+}
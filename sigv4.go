@@ -0,0 +1,148 @@
+package support
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrIncompleteSigV4 = errors.New("SigV4 region, service and credentials must be set")
+
+// SigV4Options configure AWS Signature Version 4 signing of a request, e.g. for APIs behind API Gateway or
+// an ALB with IAM auth.
+type SigV4Options struct {
+	Region          string // Region of the API, e.g. us-east-1.
+	Service         string // Signing name of the service, e.g. execute-api for API Gateway.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // If set (temporary credentials), this token is sent as X-Amz-Security-Token header.
+}
+
+// validate returns an error if the options cannot be used for signing.
+func (o *SigV4Options) validate() error {
+	if o.Region == "" || o.Service == "" || o.AccessKeyID == "" || o.SecretAccessKey == "" {
+		return ErrIncompleteSigV4
+	}
+	return nil
+}
+
+// sign sets the X-Amz-Date, X-Amz-Security-Token and Authorization headers of a request with the given method,
+// URL, host and body at the given time. Only the host and these headers are signed, so the other headers can
+// still be changed afterwards.
+func (o *SigV4Options) sign(header http.Header, method string, u *neturl.URL, host string, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	header.Set("X-Amz-Date", amzDate)
+	headers := map[string]string{"host": host, "x-amz-date": amzDate}
+	if o.SessionToken != "" {
+		header.Set("X-Amz-Security-Token", o.SessionToken)
+		headers["x-amz-security-token"] = o.SessionToken
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		method,
+		canonicalPath(u),
+		canonicalQuery(u),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + o.Region + "/" + o.Service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+o.SecretAccessKey), date)
+	for _, part := range []string{o.Region, o.Service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		o.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalPath returns the path of the given URL with every segment URI-encoded once. The segments are decoded
+// from the escaped path first, so characters escaped in the URL are not encoded twice.
+func canonicalPath(u *neturl.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(uriDecode(segment))
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery returns the URI-encoded query parameters of the given URL sorted by name and value. The parameters
+// are taken from the raw query, so a literal + is signed as such and not as a space.
+func canonicalQuery(u *neturl.URL) string {
+	var params [][2]string
+	for _, param := range strings.Split(u.RawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(param, "=")
+		params = append(params, [2]string{uriEncode(uriDecode(name)), uriEncode(uriDecode(value))})
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	encoded := make([]string, len(params))
+	for i, param := range params {
+		encoded[i] = param[0] + "=" + param[1]
+	}
+	return strings.Join(encoded, "&")
+}
+
+// uriDecode decodes the percent-encoded characters of the given URL component, keeping a + as is. Invalid escapes
+// are kept too, so they are encoded like any other character.
+func uriDecode(s string) string {
+	decoded, err := neturl.PathUnescape(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
+
+// uriEncode encodes all characters of the given string apart from the unreserved ones as required by SigV4.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
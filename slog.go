@@ -0,0 +1,50 @@
+package support
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// SlogLogger is a StructuredLogger backed by a *slog.Logger, use it as Log of HijackHttpOptions.
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a logger logging to the given slog logger, slog.Default() if nil. The attributes of
+// sessions (e.g. endpoint, direction and bytes) are logged as slog attributes.
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SlogLogger{logger: logger}
+}
+
+func (l *SlogLogger) Debugf(msg string, args ...interface{}) {
+	l.log(slog.LevelDebug, msg, args)
+}
+
+func (l *SlogLogger) Infof(msg string, args ...interface{}) {
+	l.log(slog.LevelInfo, msg, args)
+}
+
+func (l *SlogLogger) Warnf(msg string, args ...interface{}) {
+	l.log(slog.LevelWarn, msg, args)
+}
+
+func (l *SlogLogger) Errorf(msg string, args ...interface{}) {
+	l.log(slog.LevelError, msg, args)
+}
+
+// With implements StructuredLogger.
+func (l *SlogLogger) With(keysAndValues ...interface{}) StructuredLogger {
+	return &SlogLogger{logger: l.logger.With(keysAndValues...)}
+}
+
+// log logs the formatted message at the given level, the message is only formatted if the level is enabled.
+func (l *SlogLogger) log(level slog.Level, msg string, args []interface{}) {
+	ctx := context.Background()
+	if l.logger.Enabled(ctx, level) {
+		l.logger.Log(ctx, level, fmt.Sprintf(msg, args...))
+	}
+}
@@ -0,0 +1,209 @@
+package support
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/hashicorp/yamux"
+)
+
+var (
+	ErrSOCKSVersion      = errors.New("Unsupported SOCKS version")
+	ErrSOCKSDialRequired = errors.New("No dial function for SOCKS streams")
+)
+
+// SOCKS5 protocol constants (RFC 1928)
+const (
+	socksVersion         = 5
+	socksNoAuth          = 0
+	socksNoAcceptable    = 0xff
+	socksConnect         = 1
+	socksAddrIPv4        = 1
+	socksAddrDomain      = 3
+	socksAddrIPv6        = 4
+	socksSucceeded       = 0
+	socksGeneralFailure  = 1
+	socksCmdUnsupported  = 7
+	socksAddrUnsupported = 8
+)
+
+// ServeSOCKS runs a SOCKS5 server on the given listener that tunnels every connection through a logical stream
+// of a single multiplexed session described by the given options (Multiplex is enabled automatically).
+// The remote side must serve the streams using ServeSOCKSStreams. ServeSOCKS returns when the listener is closed.
+func ServeSOCKS(listener net.Listener, options HijackHttpOptions) error {
+	options.Multiplex = true
+	s, err := StartHijackHttpRequest(options)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	go func() {
+		// Stop accepting once the session is gone
+		<-s.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := serveSOCKSConn(s, conn); err != nil {
+				levels(s.options.Log).Warnf("SOCKS connection failed %#v", err)
+			}
+		}()
+	}
+}
+
+// serveSOCKSConn performs the SOCKS5 handshake on the given connection and tunnels it through a new stream.
+func serveSOCKSConn(s *Session, conn net.Conn) error {
+	target, err := socksHandshake(conn)
+	if err != nil {
+		return err
+	}
+
+	stream, err := s.OpenStream()
+	if err != nil {
+		socksReply(conn, socksGeneralFailure)
+		return err
+	}
+	defer stream.Close()
+
+	// Ask the remote side to connect to the target
+	if err := NewMessageWriter(stream).WriteMessage([]byte(target)); err != nil {
+		socksReply(conn, socksGeneralFailure)
+		return err
+	}
+	reply, err := NewMessageReader(stream, 0).ReadMessage()
+	if err != nil {
+		socksReply(conn, socksGeneralFailure)
+		return err
+	}
+	if len(reply) > 0 {
+		socksReply(conn, socksGeneralFailure)
+		return errors.New(string(reply))
+	}
+
+	if err := socksReply(conn, socksSucceeded); err != nil {
+		return err
+	}
+	splice(conn, stream)
+	return nil
+}
+
+// socksHandshake negotiates authentication and reads the CONNECT request, returning its target address.
+func socksHandshake(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", ErrSOCKSVersion
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+	if method == socksNoAcceptable {
+		return "", errors.New("No acceptable SOCKS authentication method")
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[0] != socksVersion {
+		return "", ErrSOCKSVersion
+	}
+	if request[1] != socksConnect {
+		socksReply(conn, socksCmdUnsupported)
+		return "", errors.New("Unsupported SOCKS command")
+	}
+	var host string
+	switch request[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		size := net.IPv4len
+		if request[3] == socksAddrIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socksAddrDomain:
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return "", err
+		}
+		domain := make([]byte, size[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		socksReply(conn, socksAddrUnsupported)
+		return "", errors.New("Unsupported SOCKS address type")
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply sends a reply with the given status and an unspecified bound address.
+func socksReply(conn net.Conn, status byte) error {
+	_, err := conn.Write([]byte{socksVersion, status, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// ServeSOCKSStreams serves the logical streams opened by a ServeSOCKS client on the given server session
+// (see HijackServerMux): for every stream it connects to the requested target using the given dial function and
+// pipes the stream to it. It returns when the session is closed.
+//
+// The targets are chosen by the client, so the dial function must restrict them (e.g. to an allow-list of hosts),
+// or the client can reach any address the server can, including internal services. ErrSOCKSDialRequired is returned
+// if it is nil.
+func ServeSOCKSStreams(mux *yamux.Session, dial func(network, address string) (net.Conn, error)) error {
+	if dial == nil {
+		return ErrSOCKSDialRequired
+	}
+	for {
+		stream, err := mux.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer stream.Close()
+			target, err := NewMessageReader(stream, 0).ReadMessage()
+			if err != nil {
+				return
+			}
+			conn, err := dial("tcp", string(target))
+			if err != nil {
+				NewMessageWriter(stream).WriteMessage([]byte(err.Error()))
+				return
+			}
+			defer conn.Close()
+			if err := NewMessageWriter(stream).WriteMessage(nil); err != nil {
+				return
+			}
+			splice(stream, conn)
+		}()
+	}
+}
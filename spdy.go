@@ -0,0 +1,151 @@
+package support
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/moby/spdystream"
+)
+
+// Names of the SPDY streams, as used by the Kubernetes remote command protocols.
+const (
+	SpdyStreamError  = "error"
+	SpdyStreamStdin  = "stdin"
+	SpdyStreamStdout = "stdout"
+	SpdyStreamStderr = "stderr"
+)
+
+// hijackSpdy upgrades the connection to SPDY/3.1 and transfers each of the streams in the options of the given session
+// over a separate named SPDY stream.
+func hijackSpdy(s *Session, conn net.Conn, req *http.Request, redial func() (net.Conn, error)) error {
+	options := s.options
+	req.Header.Set("Upgrade", "SPDY/3.1")
+	for _, protocol := range options.SpdyProtocols {
+		req.Header.Add("X-Stream-Protocol-Version", protocol)
+	}
+
+	// Start initial HTTP connection
+	span := s.startSpan(SpanHTTPHandshake)
+	clientconn, res, err := sendRequest(options, conn, req, redial)
+	s.endHandshakeSpan(span, res, err)
+	defer clientconn.Close()
+	if err != nil || res.StatusCode != http.StatusSwitchingProtocols {
+		if options.ErrorHandler != nil {
+			if err := options.ErrorHandler(res, err); err != nil {
+				return err
+			}
+			return nil
+		}
+
+		return err
+	}
+
+	// Hijack HTTP connection
+	rwc, br := clientconn.Hijack()
+	defer rwc.Close()
+
+	spdyConn, err := spdystream.NewConnection(&bufferedConn{rwc, br}, false)
+	if err != nil {
+		return err
+	}
+	defer spdyConn.Close()
+	go spdyConn.Serve(spdystream.NoOpStreamHandler)
+
+	errorStream, err := createSpdyStream(spdyConn, SpdyStreamError)
+	if err != nil {
+		return err
+	}
+	recorder, err := s.startRecording()
+	if err != nil {
+		return err
+	}
+	if recorder != nil {
+		defer recorder.Close()
+	}
+
+	var wg sync.WaitGroup
+	var input io.Writer
+	if in, closeInput := inputReader(options, recorder); in != nil {
+		stdin, err := createSpdyStream(spdyConn, SpdyStreamStdin)
+		if err != nil {
+			return err
+		}
+		input = stdin
+		go func() {
+			defer stdin.Close()
+			defer closeInput()
+			if _, err := io.Copy(stdin, s.countReader(in, &s.bytesOut, DirectionOut)); err != nil {
+				levels(options.Log).Warnf("Copying stdin failed %#v", err)
+			}
+		}()
+	}
+	copyOutput := func(name string, w io.Writer) error {
+		stream, err := createSpdyStream(spdyConn, name)
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := io.Copy(w, s.countReader(stream, &s.bytesIn, DirectionIn)); err != nil {
+				levels(options.Log).Warnf("Copying %s failed %#v", name, err)
+			}
+		}()
+		return nil
+	}
+	stdout, stderr, closeOutput := outputWriters(options, recorder)
+	// Both streams are copied concurrently, but may share writers (e.g. the recorder)
+	var outputMutex sync.Mutex
+	stdout, stderr = &spdyOutputWriter{&outputMutex, stdout}, &spdyOutputWriter{&outputMutex, stderr}
+	if options.OutputStream != nil {
+		if err := copyOutput(SpdyStreamStdout, stdout); err != nil {
+			return err
+		}
+	}
+	if options.ErrorStream != nil || (options.OutputStream != nil && options.CombineOutput) {
+		if err := copyOutput(SpdyStreamStderr, stderr); err != nil {
+			return err
+		}
+	}
+	s.upgrade(spdyConn, input)
+
+	message, err := ioutil.ReadAll(errorStream)
+	if err != nil {
+		return err
+	}
+	wg.Wait()
+	if err := closeOutput(); err != nil {
+		return err
+	}
+
+	return statusError(message)
+}
+
+// spdyOutputWriter serializes the writes of the output streams, which are copied concurrently.
+type spdyOutputWriter struct {
+	mutex *sync.Mutex
+	w     io.Writer
+}
+
+func (w *spdyOutputWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.w.Write(p)
+}
+
+// createSpdyStream creates a SPDY stream with the given stream type and waits for the server to accept it.
+func createSpdyStream(conn *spdystream.Connection, streamType string) (*spdystream.Stream, error) {
+	headers := http.Header{}
+	headers.Set("streamType", streamType)
+	stream, err := conn.CreateStream(headers, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Wait(); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
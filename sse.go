@@ -0,0 +1,133 @@
+package support
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSEContentType is the content type of a Server-Sent Events stream.
+const SSEContentType = "text/event-stream"
+
+// SSEWriter emits everything written to it as Server-Sent Events, for output-only streams (e.g. logs) consumed
+// by browsers. It is safe for concurrent use.
+type SSEWriter struct {
+	mutex  sync.Mutex
+	w      io.Writer
+	rc     *http.ResponseController
+	ids    bool
+	nextID uint64
+}
+
+// NewSSEWriter writes the headers of a Server-Sent Events response to w and returns a writer of its events.
+// If ids is set, every event carries a sequential id, continuing after the Last-Event-ID of a reconnecting client
+// (see LastEventID), so the handler can resume the stream where the client left off.
+func NewSSEWriter(w http.ResponseWriter, req *http.Request, ids bool) (*SSEWriter, error) {
+	rc := http.NewResponseController(w)
+	// Like for hijacked connections, deadlines of the http.Server must not end long-lived streams
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", SSEContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return nil, err
+	}
+	nextID, _ := strconv.ParseUint(LastEventID(req), 10, 64)
+	return &SSEWriter{w: w, rc: rc, ids: ids, nextID: nextID + 1}, nil
+}
+
+// LastEventID returns the id of the last event received by a client reconnecting to a Server-Sent Events stream,
+// or an empty string.
+func LastEventID(req *http.Request) string {
+	return req.Header.Get("Last-Event-ID")
+}
+
+// Write emits the given data as a single unnamed event. A trailing newline is dropped.
+func (w *SSEWriter) Write(p []byte) (int, error) {
+	if err := w.WriteEvent("", p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteEvent emits the given data as a single event with the given name (which may be empty).
+// A trailing newline is dropped, other lines are sent as separate data lines of the event.
+func (w *SSEWriter) WriteEvent(event string, data []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var buf bytes.Buffer
+	if w.ids {
+		buf.WriteString("id: " + strconv.FormatUint(w.nextID, 10) + "\n")
+		w.nextID++
+	}
+	if event != "" {
+		buf.WriteString("event: " + event + "\n")
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+	if _, err := w.w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return w.rc.Flush()
+}
+
+// EventWriter returns a writer emitting everything written to it as events with the given name (e.g. stderr).
+func (w *SSEWriter) EventWriter(event string) io.Writer {
+	return &sseEventWriter{w, event}
+}
+
+type sseEventWriter struct {
+	w     *SSEWriter
+	event string
+}
+
+func (w *sseEventWriter) Write(p []byte) (int, error) {
+	if err := w.w.WriteEvent(w.event, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// isSSERequest returns true if the client of the given request accepts Server-Sent Events.
+func isSSERequest(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && mediaType == SSEContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// lastEventIDContextKey is the context key of the Last-Event-ID passed to a StreamHandlerFunc in SSE mode.
+type lastEventIDContextKey struct{}
+
+// LastEventIDFromContext returns the Last-Event-ID of a client reconnecting to a StreamHandlerFunc in SSE mode,
+// so the handler can resume its output.
+func LastEventIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(lastEventIDContextKey{}).(string)
+	return id
+}
+
+// serveSSE calls fn without input, emitting its output as unnamed events and its error output as stderr events.
+// A returned error is emitted as error event.
+func (fn StreamHandlerFunc) serveSSE(w http.ResponseWriter, req *http.Request) {
+	events, err := NewSSEWriter(w, req, true)
+	if err != nil {
+		return
+	}
+	ctx, cancel := DefaultConnRegistry.notify(context.WithValue(req.Context(), lastEventIDContextKey{}, LastEventID(req)))
+	defer cancel()
+
+	if err := fn(ctx, http.NoBody, events, events.EventWriter("stderr")); err != nil {
+		events.WriteEvent("error", []byte(err.Error()))
+	}
+}
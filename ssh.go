@@ -0,0 +1,35 @@
+package support
+
+import (
+	neturl "net/url"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DialSSH establishes an SSH connection over a hijacked connection (see DialHijack), so the streaming endpoint
+// described by the given options can be used as transport to an SSH server behind it:
+//
+//	client, err := hijack.DialSSH(hijackOpts, &ssh.ClientConfig{
+//	    User:            "agent",
+//	    Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+//	    HostKeyCallback: ssh.FixedHostKey(hostKey),
+//	})
+//
+// The host of the options URL is passed as address to the HostKeyCallback.
+// Closing the returned client closes the hijacked connection.
+func DialSSH(options HijackHttpOptions, config *ssh.ClientConfig) (*ssh.Client, error) {
+	ep, err := neturl.Parse(options.Url)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := DialHijack(options)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, ep.Host, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
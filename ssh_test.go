@@ -0,0 +1,86 @@
+package support
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshServer returns a server running an SSH server over the hijacked connection, which answers exec requests
+// with the command.
+func sshServer(t *testing.T, hostKey ssh.Signer) *httptest.Server {
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		in, _, err := HijackServerResponse(w, http.StatusSwitchingProtocols, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn, chans, reqs, err := ssh.NewServerConn(in.(net.Conn), config)
+		if err != nil {
+			in.Close()
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			go func() {
+				defer channel.Close()
+				for req := range requests {
+					if req.Type != "exec" {
+						req.Reply(false, nil)
+						continue
+					}
+					var exec struct{ Command string }
+					ssh.Unmarshal(req.Payload, &exec)
+					req.Reply(true, nil)
+					channel.Write([]byte(exec.Command))
+					channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+					return
+				}
+			}()
+		}
+	}))
+}
+
+func TestDialSSH(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := sshServer(t, hostKey)
+	defer srv.Close()
+	client, err := DialSSH(HijackHttpOptions{Method: "POST", Url: srv.URL}, &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	out, err := session.Output("echo hello")
+	if err != nil || string(out) != "echo hello" {
+		t.Fatalf("%q %v", out, err)
+	}
+}
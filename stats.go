@@ -0,0 +1,30 @@
+package support
+
+import "time"
+
+// Directions of streamed bytes.
+const (
+	DirectionIn  = "in"  // Received from the hijacked connection (output and error streams)
+	DirectionOut = "out" // Sent over the hijacked connection (input stream)
+)
+
+// StatsRecorder receives statistics of client sessions (see HijackHttpOptions.Stats), to feed any metrics or telemetry
+// system. Its methods are called from the goroutines of sessions, possibly concurrently, and should not block.
+type StatsRecorder interface {
+	// OnDial is called once connecting to the server (including the TLS handshake) has finished or failed.
+	OnDial(duration time.Duration, err error)
+	// OnUpgrade is called once the HTTP handshake has finished or failed. The status code of the response is 0 if
+	// there is none.
+	OnUpgrade(duration time.Duration, statusCode int, err error)
+	// OnBytes is called for the bytes streamed in the given direction (DirectionIn or DirectionOut).
+	OnBytes(direction string, n int)
+	// OnClose is called once the session has ended for the given reason, e.g. CloseReasonClosed or the error.
+	OnClose(reason string)
+}
+
+// statsClosed passes the end of the session with the given final error to its StatsRecorder, if any.
+func (s *Session) statsClosed(err error) {
+	if s.options.Stats != nil {
+		s.options.Stats.OnClose(s.closeReason(err))
+	}
+}
@@ -0,0 +1,28 @@
+package support
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// remoteStatus is the status reported on the error stream by the v4.channel.k8s.io and later protocols.
+type remoteStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// statusError converts the content of an error stream into an error.
+func statusError(message []byte) error {
+	if len(message) == 0 {
+		return nil
+	}
+	var status remoteStatus
+	if err := json.Unmarshal(message, &status); err != nil {
+		// Older protocols send a plain text message
+		return errors.New(string(message))
+	}
+	if status.Status == "Success" {
+		return nil
+	}
+	return errors.New(status.Message)
+}
@@ -0,0 +1,79 @@
+package support
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// StreamConfig describes the streams a client attaches to, as given by the conventional query parameters
+// of the upgrade request.
+type StreamConfig struct {
+	TTY    bool // If set, the output is sent raw with stdout and stderr combined, otherwise multiplexed (DockerTermProtocol).
+	Stdin  bool
+	Stdout bool
+	Stderr bool
+}
+
+// ParseStreamConfig parses the `tty`, `stdin`, `stdout` and `stderr` query parameters (e.g. `1` or `true`) of the given
+// request. If none of stdin, stdout and stderr is given, all of them are enabled.
+func ParseStreamConfig(req *http.Request) (StreamConfig, error) {
+	query := req.URL.Query()
+	parse := func(name string) (bool, error) {
+		value := query.Get(name)
+		if value == "" {
+			return false, nil
+		}
+		return strconv.ParseBool(value)
+	}
+	var config StreamConfig
+	var err error
+	if config.TTY, err = parse("tty"); err != nil {
+		return config, err
+	}
+	if config.Stdin, err = parse("stdin"); err != nil {
+		return config, err
+	}
+	if config.Stdout, err = parse("stdout"); err != nil {
+		return config, err
+	}
+	if config.Stderr, err = parse("stderr"); err != nil {
+		return config, err
+	}
+	if !query.Has("stdin") && !query.Has("stdout") && !query.Has("stderr") {
+		config.Stdin, config.Stdout, config.Stderr = true, true, true
+	}
+	return config, nil
+}
+
+// streams returns the streams passed to a handler for the given hijacked streams: streams that are not enabled
+// are empty or discarded, the output streams are multiplexed unless TTY is set.
+func (c StreamConfig) streams(in io.Reader, out io.Writer) (io.Reader, io.Writer, io.Writer) {
+	stdout, stderr := out, out
+	if !c.TTY {
+		stdout, stderr = NewStdWriters(out)
+	}
+	if !c.Stdin {
+		in = http.NoBody
+	}
+	if !c.Stdout {
+		stdout = io.Discard
+	}
+	if !c.Stderr {
+		stderr = io.Discard
+	}
+	return in, stdout, stderr
+}
+
+// streamConfigContextKey is the context key of the StreamConfig passed to a StreamHandlerFunc.
+type streamConfigContextKey struct{}
+
+// StreamConfigFromContext returns the StreamConfig of the request served by a StreamHandlerFunc.
+func StreamConfigFromContext(ctx context.Context) StreamConfig {
+	config, ok := ctx.Value(streamConfigContextKey{}).(StreamConfig)
+	if !ok {
+		return StreamConfig{Stdin: true, Stdout: true, Stderr: true}
+	}
+	return config
+}
@@ -0,0 +1,61 @@
+package support
+
+import (
+	"io"
+	"sync"
+
+	docker "github.com/giantswarm/hijack-stream-support/docker"
+)
+
+// teeWriter mirrors a stream to a writer of TeeOutput or TeeInput. Its failures do not affect the stream: they are
+// logged and the writer is not written to anymore.
+type teeWriter struct {
+	mutex  sync.Mutex
+	w      io.Writer
+	log    docker.Logger
+	name   string
+	failed bool
+	redact *redactingWriter // Set if secrets are redacted before mirroring
+}
+
+// newTeeWriter returns a writer mirroring to the given writer of the options with the given name, redacting secrets
+// if the options use Redact. It returns nil if the writer is nil.
+func newTeeWriter(options HijackHttpOptions, w io.Writer, name string) *teeWriter {
+	if w == nil {
+		return nil
+	}
+	t := &teeWriter{w: w, log: options.Log, name: name}
+	if options.Redact != nil {
+		t.redact = &redactingWriter{write: t.mirror, redact: options.Redact}
+	}
+	return t
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	if t.redact != nil {
+		return t.redact.Write(p)
+	}
+	t.mirror(p)
+	return len(p), nil
+}
+
+// Flush mirrors data buffered for redaction.
+func (t *teeWriter) Flush() {
+	if t.redact != nil {
+		t.redact.Flush()
+	}
+}
+
+// mirror writes the given data to the writer unless it has failed before.
+func (t *teeWriter) mirror(p []byte) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.failed {
+		return nil
+	}
+	if _, err := t.w.Write(p); err != nil {
+		t.failed = true
+		levels(t.log).Warnf("Writing to %s failed, the stream is not mirrored anymore %#v", t.name, err)
+	}
+	return nil
+}
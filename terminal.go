@@ -0,0 +1,42 @@
+package support
+
+import (
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// Terminal is a local terminal that has been put into raw mode.
+type Terminal struct {
+	fd    int
+	state *term.State
+	once  sync.Once
+}
+
+// MakeRawTerminal puts the terminal with the given file descriptor (e.g. int(os.Stdin.Fd())) into raw mode.
+// If the file descriptor is not a terminal, nothing is changed.
+// The caller must call Restore on the returned terminal, typically using defer so it also happens on panics.
+func MakeRawTerminal(fd int) (*Terminal, error) {
+	t := &Terminal{fd: fd}
+	if !term.IsTerminal(fd) {
+		return t, nil
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	t.state = state
+	return t, nil
+}
+
+// Restore restores the state of the terminal from before it was put into raw mode.
+// It is safe to call Restore multiple times.
+func (t *Terminal) Restore() error {
+	var err error
+	t.once.Do(func() {
+		if t.state != nil {
+			err = term.Restore(t.fd, t.state)
+		}
+	})
+	return err
+}
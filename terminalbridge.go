@@ -0,0 +1,138 @@
+package support
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// TerminalMessage is a message of the protocol between a TerminalBridge and a browser terminal (e.g. xterm.js).
+// Browsers send "input" messages with the typed Data and "resize" messages with the terminal size in Cols and Rows
+// as JSON. The bridge sends the output of the backend session as binary messages and a final "exit" message with
+// the exit Code (and the Error if the session failed) as JSON text message.
+type TerminalMessage struct {
+	Type  string `json:"type"`
+	Data  string `json:"data,omitempty"`
+	Cols  uint   `json:"cols,omitempty"`
+	Rows  uint   `json:"rows,omitempty"`
+	Code  int    `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// TerminalBridge is an http.Handler accepting WebSocket connections of browser terminals (e.g. xterm.js) and
+// bridging them to a backend hijacked session, so web terminals can front any hijack-capable API.
+// Input and resize messages of the browser (see TerminalMessage) are passed to the input stream and Session.Resize
+// of the backend session, its output is sent to the browser.
+type TerminalBridge struct {
+	Backend     func(req *http.Request) (HijackHttpOptions, error) // Returns the options of the backend session for the request of a browser. Their streams are set by the bridge, the error stream is combined with the output.
+	CheckOrigin func(req *http.Request) bool                       // If set, connections are only accepted if this function returns true, by default only from the same origin.
+}
+
+// ServeHTTP implements http.Handler. Requests that are no WebSocket upgrades are answered with 400 Bad Request,
+// requests from other origins with 403 Forbidden and requests for which Backend fails with 502 Bad Gateway.
+func (b *TerminalBridge) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !isWebSocketRequest(req) {
+		http.Error(w, "WebSocket upgrade required", http.StatusBadRequest)
+		return
+	}
+	checkOrigin := b.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	options, err := b.Backend(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		ws.PayloadType = websocket.BinaryFrame
+		bridgeTerminal(ws, options)
+	}}
+	server.ServeHTTP(w, req)
+}
+
+// bridgeTerminal streams a backend session described by the given options to/from the given browser connection.
+func bridgeTerminal(ws *websocket.Conn, options HijackHttpOptions) {
+	var mutex sync.Mutex
+	send := func(codec websocket.Codec, v interface{}) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return codec.Send(ws, v)
+	}
+
+	in, inWriter := io.Pipe()
+	defer in.Close()
+	output := &terminalWriter{send}
+	options.InputStream = in
+	options.OutputStream = output
+	options.ErrorStream = output
+	options.RawTerminal = nil
+	s, err := StartHijackHttpRequest(options)
+	if err != nil {
+		send(websocket.JSON, TerminalMessage{Type: "exit", Code: 1, Error: err.Error()})
+		return
+	}
+	go func() {
+		// The backend session ends when the browser goes away
+		defer s.Close()
+		defer inWriter.Close()
+		for {
+			var msg TerminalMessage
+			if err := websocket.JSON.Receive(ws, &msg); err != nil {
+				return
+			}
+			switch msg.Type {
+			case "input":
+				if _, err := io.WriteString(inWriter, msg.Data); err != nil {
+					return
+				}
+			case "resize":
+				if msg.Cols > 0 && msg.Rows > 0 {
+					s.Resize(msg.Cols, msg.Rows)
+				}
+			}
+		}
+	}()
+
+	exit := TerminalMessage{Type: "exit"}
+	if err := s.Wait(); err != nil {
+		var exitErr *ExitError
+		if errors.As(err, &exitErr) {
+			exit.Code = exitErr.Code
+		} else {
+			exit.Code = 1
+			exit.Error = err.Error()
+		}
+	}
+	send(websocket.JSON, exit)
+}
+
+// terminalWriter sends everything written to it as binary messages to a browser terminal.
+type terminalWriter struct {
+	send func(codec websocket.Codec, v interface{}) error
+}
+
+func (w *terminalWriter) Write(p []byte) (int, error) {
+	if err := w.send(websocket.Message, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// sameOrigin returns true if the given request has no Origin header or one matching its host.
+func sameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := neturl.Parse(origin)
+	return err == nil && u.Host == req.Host
+}
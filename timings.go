@@ -0,0 +1,51 @@
+package support
+
+import (
+	"io"
+	"time"
+)
+
+// Timings are the durations of the phases of establishing a session (see Session.Timings), like httptrace for HTTP
+// clients, to tell whether latency is caused by the network or by the server. Phases that have not happened (yet)
+// are zero.
+type Timings struct {
+	DNS          time.Duration // Looking up the host, zero for IP addresses and transports registered by RegisterTransport
+	Connect      time.Duration // Connecting to the server, including the TLS handshake for registered transports and HTTP/2
+	TLSHandshake time.Duration // The TLS handshake
+	Upgrade      time.Duration // Sending the handshake request until the connection has been upgraded
+	FirstByte    time.Duration // The upgrade until the first byte of the output streams has been received
+}
+
+// Timings returns the timings of the session so far.
+func (s *Session) Timings() Timings {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.timings
+}
+
+// recordTiming records timings of the session with the given function.
+func (s *Session) recordTiming(set func(t *Timings)) {
+	s.mutex.Lock()
+	set(&s.timings)
+	s.mutex.Unlock()
+}
+
+// firstByteReader records the time until the first byte has been read from the hijacked connection.
+type firstByteReader struct {
+	io.Reader
+	s        *Session
+	upgraded time.Time
+	done     bool
+}
+
+func (r *firstByteReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 && !r.done {
+		r.done = true
+		firstByte := time.Since(r.upgraded)
+		r.s.recordTiming(func(t *Timings) {
+			t.FirstByte = firstByte
+		})
+	}
+	return n, err
+}
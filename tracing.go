@@ -0,0 +1,187 @@
+package support
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Names of the spans of a traced session (see HijackHttpOptions.Tracer).
+const (
+	SpanSession       = "hijack.session"
+	SpanDial          = "hijack.dial"
+	SpanTLSHandshake  = "hijack.tls_handshake"
+	SpanHTTPHandshake = "hijack.http_handshake"
+	SpanStream        = "hijack.stream"
+)
+
+// startTrace starts the span of the session if its options have a Tracer.
+func (s *Session) startTrace() {
+	options := s.options
+	if options.Tracer == nil {
+		return
+	}
+	ctx := options.TraceContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	s.traceCtx, s.span = options.Tracer.Start(ctx, SpanSession, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("http.request.method", options.Method),
+		attribute.String("url.full", redactedURL(options.Url)),
+	))
+	if options.RequestID != "" {
+		s.span.SetAttributes(attribute.String("hijack.request_id", options.RequestID))
+	}
+}
+
+// startSpan starts a span with the given name as child of the span of the session and enters the phase of the session
+// with this name (without hijack. prefix). If the session is not traced, a span that is not recording is returned.
+func (s *Session) startSpan(name string) trace.Span {
+	s.phase = strings.TrimPrefix(name, "hijack.")
+	s.phaseStarted = time.Now()
+	if s.span == nil {
+		return trace.SpanFromContext(context.Background())
+	}
+	_, span := s.options.Tracer.Start(s.traceCtx, name)
+	return span
+}
+
+// endSpan ends the given span, recording the given error if any.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// endHandshakeSpan ends the given span of an HTTP handshake with the given response and records its duration.
+func (s *Session) endHandshakeSpan(span trace.Span, res *http.Response, err error) {
+	duration := time.Since(s.phaseStarted)
+	s.options.Metrics.handshakeDone(duration)
+	s.recordTiming(func(t *Timings) {
+		t.Upgrade = duration
+	})
+	statusCode := 0
+	if res != nil {
+		statusCode = res.StatusCode
+		span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
+	}
+	if s.options.Stats != nil {
+		s.options.Stats.OnUpgrade(duration, statusCode, err)
+	}
+	endSpan(span, err)
+}
+
+// injectTrace adds the context of the span of the session to the given request headers (e.g. the W3C traceparent
+// header), using the propagator registered with otel.SetTextMapPropagator.
+func (s *Session) injectTrace(header http.Header) {
+	if s.span != nil {
+		otel.GetTextMapPropagator().Inject(s.traceCtx, propagation.HeaderCarrier(header))
+	}
+}
+
+// traceStream starts the span of the stream once the connection has been hijacked.
+func (s *Session) traceStream() {
+	span := s.startSpan(SpanStream)
+	if s.span != nil {
+		s.span.AddEvent("hijacked")
+		s.streamSpan = span
+	}
+}
+
+// countReader returns the given reader counting the bytes read from it in n if the session is traced, has metrics
+// or a StatsRecorder, which is passed the bytes with the given direction.
+func (s *Session) countReader(r io.Reader, n *int64, direction string) io.Reader {
+	if !s.countsBytes() {
+		return r
+	}
+	return &countingReader{r, n, direction, s.options.Stats}
+}
+
+// countsBytes returns true if the session counts the streamed bytes, i.e. it is traced, has metrics or a StatsRecorder.
+func (s *Session) countsBytes() bool {
+	return s.span != nil || s.options.Metrics != nil || s.options.Stats != nil
+}
+
+// countBytes counts n bytes transferred in the given direction in counter, like the readers of countReader.
+func (s *Session) countBytes(n int, counter *int64, direction string) {
+	atomic.AddInt64(counter, int64(n))
+	if n > 0 && s.options.Stats != nil {
+		s.options.Stats.OnBytes(direction, n)
+	}
+}
+
+// countWriter returns the given writer counting the bytes written to it in n like countReader.
+func (s *Session) countWriter(w io.Writer, n *int64, direction string) io.Writer {
+	if !s.countsBytes() {
+		return w
+	}
+	return &countingWriter{w, s, n, direction}
+}
+
+// endTrace ends the spans of the session with the given final error.
+func (s *Session) endTrace(err error) {
+	if s.span == nil {
+		return
+	}
+	if s.streamSpan != nil {
+		s.streamSpan.AddEvent("closed", trace.WithAttributes(attribute.String("hijack.close_reason", s.closeReason(err))))
+		s.streamSpan.SetAttributes(
+			attribute.Int64("hijack.bytes_in", atomic.LoadInt64(&s.bytesIn)),
+			attribute.Int64("hijack.bytes_out", atomic.LoadInt64(&s.bytesOut)),
+		)
+		endSpan(s.streamSpan, err)
+	}
+	endSpan(s.span, err)
+}
+
+// closeReason returns the reason the session ended for with the given final error.
+func (s *Session) closeReason(err error) string {
+	if status, ok := s.ExitStatus(); ok && status.CloseReason != "" {
+		return status.CloseReason
+	} else if err != nil {
+		return err.Error()
+	}
+	return CloseReasonClosed
+}
+
+// countingReader counts the bytes read from a reader.
+type countingReader struct {
+	io.Reader
+	n         *int64
+	direction string
+	stats     StatsRecorder
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	if n > 0 && r.stats != nil {
+		r.stats.OnBytes(r.direction, n)
+	}
+	return n, err
+}
+
+// countingWriter counts the bytes written to a writer.
+type countingWriter struct {
+	io.Writer
+	s         *Session
+	n         *int64
+	direction string
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.s.countBytes(n, w.n, w.direction)
+	return n, err
+}
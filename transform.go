@@ -0,0 +1,91 @@
+package support
+
+import "io"
+
+// OutputTransform returns the writer the output or error stream is written through to the given writer, e.g. to
+// filter or rewrite it (see HijackHttpOptions.TransformOutput). If the returned writer implements io.Closer, it is
+// closed once streaming has finished, e.g. to flush a transform.NewWriter of golang.org/x/text/transform.
+type OutputTransform func(w io.Writer) io.Writer
+
+// InputTransform returns the reader the input stream is read through from the given reader, e.g. a
+// transform.NewReader of golang.org/x/text/transform (see HijackHttpOptions.TransformInput).
+type InputTransform func(r io.Reader) io.Reader
+
+// MapOutput returns an OutputTransform passing every chunk of data written to the given function and writing its
+// result instead.
+func MapOutput(f func(p []byte) []byte) OutputTransform {
+	return func(w io.Writer) io.Writer {
+		return &mapWriter{w, f}
+	}
+}
+
+// MapInput returns an InputTransform passing every chunk of data read to the given function and returning its result
+// instead.
+func MapInput(f func(p []byte) []byte) InputTransform {
+	return func(r io.Reader) io.Reader {
+		return &mapReader{r: r, f: f}
+	}
+}
+
+// mapWriter writes the data written to it mapped by a function.
+type mapWriter struct {
+	w io.Writer
+	f func(p []byte) []byte
+}
+
+func (w *mapWriter) Write(p []byte) (int, error) {
+	if _, err := w.w.Write(w.f(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// mapReader returns the data read mapped by a function.
+type mapReader struct {
+	r       io.Reader
+	f       func(p []byte) []byte
+	pending []byte // Mapped data not returned yet
+}
+
+func (r *mapReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		n, err := r.r.Read(p)
+		if n > 0 {
+			r.pending = r.f(append([]byte(nil), p[:n]...))
+		}
+		if err != nil && len(r.pending) == 0 {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// transformOutput returns the given output and error streams written through the TransformOutput of the given options
+// and a function closing the writers returned by it.
+func transformOutput(options HijackHttpOptions, stdout, stderr io.Writer) (io.Writer, io.Writer, func() error) {
+	if options.TransformOutput == nil {
+		return stdout, stderr, func() error { return nil }
+	}
+	stdout = options.TransformOutput(stdout)
+	writers := []io.Writer{stdout}
+	if options.ErrorStream == nil && options.CombineOutput {
+		// The error stream is written to the output stream, so it is written through the same writer
+		stderr = stdout
+	} else {
+		stderr = options.TransformOutput(stderr)
+		writers = append(writers, stderr)
+	}
+	return stdout, stderr, func() error {
+		var err error
+		for _, w := range writers {
+			if c, ok := w.(io.Closer); ok {
+				if cerr := c.Close(); err == nil {
+					err = cerr
+				}
+			}
+		}
+		return err
+	}
+}
@@ -0,0 +1,123 @@
+package support
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http/httptrace"
+	neturl "net/url"
+	"os"
+	"sync"
+	"time"
+
+	docker "github.com/giantswarm/hijack-stream-support/docker"
+)
+
+// DialFunc establishes a connection to the endpoint described by the given URL.
+type DialFunc func(ep *neturl.URL) (net.Conn, error)
+
+var (
+	transportsMutex sync.RWMutex
+	registered      = map[string]bool{} // Schemes with transports registered by RegisterTransport
+	transports      = map[string]DialFunc{
+		"unix":  dialUnix,
+		"http":  dialTCP,
+		"https": dialTLS,
+		"ws":    dialTCP,
+		"wss":   dialTLS,
+	}
+)
+
+// RegisterTransport registers the function used to dial URLs with the given scheme.
+// This allows adding transports (e.g. vsock, npipe, ssh) without modifying this package.
+// URLs with a scheme that has no registered transport are dialed over plain TCP.
+func RegisterTransport(scheme string, dial DialFunc) {
+	transportsMutex.Lock()
+	defer transportsMutex.Unlock()
+	transports[scheme] = dial
+	registered[scheme] = true
+}
+
+// isRegisteredTransport returns true if a transport has been registered for the given scheme by RegisterTransport.
+func isRegisteredTransport(scheme string) bool {
+	transportsMutex.RLock()
+	defer transportsMutex.RUnlock()
+	return registered[scheme]
+}
+
+// dial connects to the endpoint of the given URL using the transport registered for its scheme.
+func dial(ep *neturl.URL) (net.Conn, error) {
+	transportsMutex.RLock()
+	dial, ok := transports[ep.Scheme]
+	transportsMutex.RUnlock()
+	if !ok {
+		dial = dialTCP
+	}
+	return dial(ep)
+}
+
+// dialUnix connects to the unix socket at the path of the given URL (see splitUnixPath).
+func dialUnix(ep *neturl.URL) (net.Conn, error) {
+	socket, _ := splitUnixPath(ep.Path)
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("Dialing %s %s failed: %w", "unix", socket, err)
+	}
+	return conn, nil
+}
+
+// splitUnixPath splits the path of a unix URL into the path of the socket and the request path following it, e.g.
+// /var/run/docker.sock/v1.41/info into /var/run/docker.sock and /v1.41/info. The socket is the first existing socket
+// file along the path, without one the whole path is the socket and the request path is /.
+func splitUnixPath(path string) (socket, request string) {
+	for i := 1; i < len(path); i++ {
+		if path[i] != '/' {
+			continue
+		}
+		if info, err := os.Stat(path[:i]); err == nil && info.Mode()&os.ModeSocket != 0 {
+			return path[:i], path[i:]
+		}
+	}
+	return path, "/"
+}
+
+// dialTCP connects to the host of the given URL over TCP.
+func dialTCP(ep *neturl.URL) (net.Conn, error) {
+	conn, _, err := dialTCPTimed(ep)
+	return conn, err
+}
+
+// dialTCPTimed connects to the host of the given URL over TCP like dialTCP and returns the duration of the DNS lookup
+// of the host, zero if it is an IP address.
+func dialTCPTimed(ep *neturl.URL) (net.Conn, time.Duration, error) {
+	address, err := DialAddress(ep)
+	if err != nil {
+		return nil, 0, err
+	}
+	var dnsStart time.Time
+	var dns time.Duration
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { dns = time.Since(dnsStart) },
+	})
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, dns, fmt.Errorf("Dialing %s %s failed: %w", "tcp", address, err)
+	}
+	return conn, dns, nil
+}
+
+// dialTLS connects to the host of the given URL over TCP and performs a TLS handshake.
+func dialTLS(ep *neturl.URL) (net.Conn, error) {
+	address, err := DialAddress(ep)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{}
+	conn, err := docker.TLSDial("tcp", address, config)
+	if err != nil {
+		return nil, fmt.Errorf("TLS Dialing %s %s failed: %w", "tcp", address, err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,208 @@
+package support
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// maxDatagramSize is the maximum size of an encapsulated UDP datagram.
+	maxDatagramSize = 64 * 1024
+	// UDPIdleTimeout is the time after which the tunnel of a local UDP peer without traffic is closed.
+	UDPIdleTimeout = 2 * time.Minute
+)
+
+// ForwardLocalUDP listens on the given local UDP address and tunnels the datagrams of every local peer
+// to the remote side, see ForwardUDP.
+func ForwardLocalUDP(address string, options HijackHttpOptions) error {
+	pc, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	return ForwardUDP(pc, options)
+}
+
+// ForwardUDP reads datagrams from the given packet connection and tunnels them, length-prefixed, through a hijacked
+// session described by the given options (the streams of the options are ignored). Datagrams received back are sent
+// to the local peer they belong to. The server must pass the hijacked streams to ServeUDP.
+// With the Multiplex option, a single session is used and every local peer gets its own logical stream,
+// otherwise a new session is established per local peer. Tunnels of idle peers are closed after UDPIdleTimeout.
+// ForwardUDP returns when the packet connection is closed.
+func ForwardUDP(pc net.PacketConn, options HijackHttpOptions) error {
+	options, err := prepareOptions(options)
+	if err != nil {
+		return err
+	}
+
+	var s *Session
+	if options.Multiplex {
+		if s, err = StartHijackHttpRequest(options); err != nil {
+			return err
+		}
+		defer s.Close()
+		go func() {
+			// Stop reading once the session is gone
+			<-s.Done()
+			pc.Close()
+		}()
+	}
+
+	var mutex sync.Mutex
+	peers := make(map[string]*udpPeer)
+	defer func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, peer := range peers {
+			peer.close()
+		}
+	}()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		peer, ok := peers[addr.String()]
+		if !ok {
+			if peer, err = openUDPPeer(s, options); err != nil {
+				mutex.Unlock()
+				levels(options.Log).Errorf("Opening UDP tunnel failed %#v", err)
+				continue
+			}
+			peers[addr.String()] = peer
+			go func() {
+				peer.receive(pc, addr)
+				mutex.Lock()
+				if peers[addr.String()] == peer {
+					delete(peers, addr.String())
+				}
+				mutex.Unlock()
+			}()
+		}
+		mutex.Unlock()
+		if err := peer.send(buf[:n]); err != nil {
+			levels(options.Log).Warnf("Tunneling UDP datagram failed %#v", err)
+			peer.close()
+		}
+	}
+}
+
+// udpPeer is the tunnel of a single local UDP peer.
+type udpPeer struct {
+	stream io.ReadWriteCloser
+	writer *MessageWriter
+	idle   *time.Timer
+	once   sync.Once
+}
+
+// openUDPPeer opens a logical stream on the given session or, if nil, a new session for a local peer.
+func openUDPPeer(s *Session, options HijackHttpOptions) (*udpPeer, error) {
+	var stream io.ReadWriteCloser
+	if s != nil {
+		var err error
+		if stream, err = s.OpenStream(); err != nil {
+			return nil, err
+		}
+	} else {
+		inReader, inWriter := io.Pipe()
+		outReader, outWriter := io.Pipe()
+		peerOptions := options
+		peerOptions.InputStream = inReader
+		peerOptions.OutputStream = outWriter
+		go func() {
+			err := HijackHttpRequest(peerOptions)
+			if err != nil {
+				levels(options.Log).Errorf("UDP tunnel failed %#v", err)
+			}
+			outWriter.CloseWithError(err)
+		}()
+		stream = &udpPipe{outReader, inWriter}
+	}
+	peer := &udpPeer{stream: stream, writer: NewMessageWriter(stream)}
+	peer.idle = time.AfterFunc(UDPIdleTimeout, peer.close)
+	return peer, nil
+}
+
+// send tunnels a datagram of the local peer.
+func (p *udpPeer) send(datagram []byte) error {
+	p.idle.Reset(UDPIdleTimeout)
+	return p.writer.WriteMessage(datagram)
+}
+
+// receive sends the datagrams received through the tunnel to the given local address until the tunnel is closed.
+func (p *udpPeer) receive(pc net.PacketConn, addr net.Addr) {
+	defer p.close()
+	reader := NewMessageReader(p.stream, maxDatagramSize)
+	for {
+		datagram, err := reader.ReadMessage()
+		if err != nil {
+			return
+		}
+		p.idle.Reset(UDPIdleTimeout)
+		if _, err := pc.WriteTo(datagram, addr); err != nil {
+			return
+		}
+	}
+}
+
+func (p *udpPeer) close() {
+	p.once.Do(func() {
+		p.idle.Stop()
+		p.stream.Close()
+	})
+}
+
+// udpPipe connects a local peer to the streams of its own session.
+type udpPipe struct {
+	*io.PipeReader
+	*io.PipeWriter
+}
+
+func (p *udpPipe) Close() error {
+	p.PipeWriter.Close()
+	return p.PipeReader.Close()
+}
+
+// ServeUDP relays the length-prefixed datagrams of a ForwardUDP client, read from the given hijacked input stream,
+// to the given UDP address and writes the datagrams received from it to the given hijacked output stream.
+// It returns when the input stream ends.
+func ServeUDP(in io.Reader, out io.Writer, address string) error {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	go func() {
+		writer := NewMessageWriter(out)
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			if err := writer.WriteMessage(buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+
+	reader := NewMessageReader(in, maxDatagramSize)
+	for {
+		datagram, err := reader.ReadMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := conn.Write(datagram); err != nil {
+			return err
+		}
+	}
+}
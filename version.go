@@ -0,0 +1,79 @@
+package support
+
+import (
+	"errors"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+)
+
+var ErrMissingAPIVersion = errors.New("API version not reported")
+
+var versionPrefix = regexp.MustCompile(`^/v[0-9][0-9.]*(/|$)`)
+
+// NegotiateAPIVersion pings the docker daemon at the given endpoint and returns the API version (e.g. "1.41")
+// it reports in the `Api-Version` response header.
+func NegotiateAPIVersion(endpoint string, header http.Header) (string, error) {
+	client, err := newAPIClient(endpoint, header)
+	if err != nil {
+		return "", err
+	}
+	res, err := client.get("/_ping")
+	if err != nil {
+		return "", err
+	}
+	version := res.Header.Get("Api-Version")
+	if version == "" {
+		return "", ErrMissingAPIVersion
+	}
+	return version, nil
+}
+
+// resolveAPIVersion returns the given API version, negotiating it with the daemon at the given endpoint if it is "auto".
+func resolveAPIVersion(endpoint, version string, header http.Header) (string, error) {
+	if version != "auto" {
+		return version, nil
+	}
+	return NegotiateAPIVersion(endpoint, header)
+}
+
+// VersionedURL returns the given URL with its path prefixed by the given API version (/v1.xx),
+// replacing an existing version prefix. For unix URLs, the request path following the socket is prefixed.
+func VersionedURL(rawurl, version string) (string, error) {
+	u, err := neturl.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "unix" {
+		// The version prefixes the request path following the socket
+		socket, path := splitUnixPath(u.Path)
+		u.Path = socket + versionedPath(path, version)
+	} else {
+		u.Path = versionedPath(u.Path, version)
+	}
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// versionedPath prefixes the given path with the given API version, replacing an existing version prefix.
+func versionedPath(path, version string) string {
+	path = versionPrefix.ReplaceAllString(path, "/")
+	if version == "" {
+		return path
+	}
+	return "/v" + version + path
+}
+
+// negotiateURL pings the daemon serving the given URL and returns the URL prefixed with its API version.
+func negotiateURL(rawurl string, header http.Header) (string, error) {
+	ep, err := neturl.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	endpoint, _ := splitEndpointURL(ep)
+	version, err := NegotiateAPIVersion(endpoint, header)
+	if err != nil {
+		return "", err
+	}
+	return VersionedURL(rawurl, version)
+}
@@ -0,0 +1,198 @@
+package support
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+var ErrWebSocketWithData = errors.New("Data cannot be sent over a websocket")
+
+// isWebSocket returns true if the given URL must be streamed over a websocket.
+func isWebSocket(ep *neturl.URL) bool {
+	return ep.Scheme == "ws" || ep.Scheme == "wss"
+}
+
+// isWebSocketRequest returns true if the given request asks for a websocket upgrade.
+func isWebSocketRequest(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+// serveWebSocket accepts the websocket upgrade of the given request and calls fn with streams transferred over
+// binary messages, using the v4.channel.k8s.io channels if the client asked for them.
+func (fn StreamHandlerFunc) serveWebSocket(w http.ResponseWriter, req *http.Request) {
+	config, err := ParseStreamConfig(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := DefaultConnRegistry.reserve(); err != nil {
+		hijackError(w, err)
+		return
+	}
+	tracked := false
+	defer func() {
+		if !tracked {
+			// The handshake failed
+			DefaultConnRegistry.release()
+		}
+	}()
+	server := websocket.Server{
+		Handshake: func(config *websocket.Config, req *http.Request) error {
+			for _, protocol := range config.Protocol {
+				if protocol == ChannelProtocolName {
+					config.Protocol = []string{ChannelProtocolName}
+					return nil
+				}
+			}
+			config.Protocol = nil
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			conn := DefaultConnRegistry.track(ws, req)
+			tracked = true
+			defer conn.Close()
+			ws.PayloadType = websocket.BinaryFrame
+			if len(ws.Config().Protocol) == 0 {
+				fn.serveStd(req, config, conn, conn)
+				return
+			}
+			fn.serveChannels(req, ws, conn)
+		},
+	}
+	server.ServeHTTP(w, req)
+}
+
+// serveChannels calls fn with the streams (de)multiplexed using the channel byte prefix of the v4.channel.k8s.io
+// protocol and sends the result on the error channel. The transferred bytes are counted on the given tracked connection.
+func (fn StreamHandlerFunc) serveChannels(req *http.Request, ws *websocket.Conn, conn *trackedConn) {
+	ctx, cancel := DefaultConnRegistry.notify(context.WithoutCancel(req.Context()))
+	defer cancel()
+
+	var mutex sync.Mutex
+	send := func(channel byte, data []byte) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		atomic.AddInt64(&conn.bytesOut, int64(len(data)+1))
+		return websocket.Message.Send(ws, append([]byte{channel}, data...))
+	}
+
+	in, inWriter := io.Pipe()
+	go func() {
+		for {
+			var msg []byte
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				inWriter.CloseWithError(err)
+				return
+			}
+			atomic.AddInt64(&conn.bytesIn, int64(len(msg)))
+			if len(msg) == 0 || msg[0] != ChannelStdin {
+				// Other channels (e.g. resize) are not passed to the handler
+				continue
+			}
+			if _, err := inWriter.Write(msg[1:]); err != nil {
+				return
+			}
+		}
+	}()
+	defer in.Close()
+
+	err := fn(ctx, in, &channelSendWriter{send, ChannelStdout}, &channelSendWriter{send, ChannelStderr})
+	status := remoteStatus{Status: "Success"}
+	if err != nil {
+		status = remoteStatus{Status: "Failure", Message: err.Error()}
+	}
+	message, _ := json.Marshal(status)
+	send(ChannelError, message)
+}
+
+// channelSendWriter writes everything written to it as messages on a channel using the given send function.
+type channelSendWriter struct {
+	send    func(channel byte, data []byte) error
+	channel byte
+}
+
+func (w *channelSendWriter) Write(p []byte) (int, error) {
+	if err := w.send(w.channel, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// hijackWebSocket establishes a websocket connection to the given URL and streams data from/to
+// the streams in the options of the given session using binary messages.
+func hijackWebSocket(s *Session, ep *neturl.URL) error {
+	options := s.options
+	if options.Data != nil {
+		return ErrWebSocketWithData
+	}
+
+	origin := *ep
+	origin.Scheme = "http"
+	if ep.Scheme == "wss" {
+		origin.Scheme = "https"
+	}
+	config, err := websocket.NewConfig(ep.String(), origin.String())
+	if err != nil {
+		return err
+	}
+	for k, values := range options.Header {
+		config.Header[k] = values
+	}
+	if options.Jar != nil {
+		// The response of the handshake is not available, so websocket connections only send cookies
+		addCookies(config.Header, options.Jar, ep)
+	}
+	if options.SigV4 != nil {
+		options.SigV4.sign(config.Header, http.MethodGet, ep, ep.Host, nil, time.Now())
+	}
+	if options.ChannelProtocol {
+		config.Protocol = []string{ChannelProtocolName}
+	}
+
+	conn, err := dialSession(s, ep)
+	if err != nil {
+		return err
+	}
+	s.injectTrace(config.Header)
+	span := s.startSpan(SpanHTTPHandshake)
+	ws, err := websocket.NewClient(config, conn)
+	s.endHandshakeSpan(span, nil, err)
+	if err != nil {
+		conn.Close()
+		if options.ErrorHandler != nil {
+			return options.ErrorHandler(nil, err)
+		}
+		return err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	if s.detach {
+		s.detachConn(ws)
+		return nil
+	}
+	defer ws.Close()
+	var input io.Writer = ws
+	if options.ChannelProtocol {
+		s.setChannelSender(func(channel byte, data []byte) error {
+			return websocket.Message.Send(ws, append([]byte{channel}, data...))
+		})
+		input = &channelWriter{s, ChannelStdin}
+	}
+	s.upgrade(ws, input)
+
+	if options.ChannelProtocol {
+		return streamChannels(s, ws)
+	}
+
+	// Stream data
+	return streamData(s, ws, ws)
+}
@@ -0,0 +1,44 @@
+// Package zaplog adapts zap loggers to the Log of HijackHttpOptions:
+//
+//	hijackOpts.Log = zaplog.New(logger)
+//
+// The attributes of sessions (e.g. endpoint, direction and bytes) are logged as zap fields.
+package zaplog
+
+import (
+	"go.uber.org/zap"
+
+	support "github.com/giantswarm/hijack-stream-support"
+)
+
+// Logger is a support.StructuredLogger backed by a zap logger.
+type Logger struct {
+	sugar *zap.SugaredLogger
+}
+
+// New returns a logger logging to the given zap logger.
+func New(logger *zap.Logger) *Logger {
+	// Report the callers of the adapter instead of the adapter itself
+	return &Logger{sugar: logger.WithOptions(zap.AddCallerSkip(1)).Sugar()}
+}
+
+func (l *Logger) Debugf(msg string, args ...interface{}) {
+	l.sugar.Debugf(msg, args...)
+}
+
+func (l *Logger) Infof(msg string, args ...interface{}) {
+	l.sugar.Infof(msg, args...)
+}
+
+func (l *Logger) Warnf(msg string, args ...interface{}) {
+	l.sugar.Warnf(msg, args...)
+}
+
+func (l *Logger) Errorf(msg string, args ...interface{}) {
+	l.sugar.Errorf(msg, args...)
+}
+
+// With implements support.StructuredLogger.
+func (l *Logger) With(keysAndValues ...interface{}) support.StructuredLogger {
+	return &Logger{sugar: l.sugar.With(keysAndValues...)}
+}